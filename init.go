@@ -3,7 +3,6 @@ package services
 import (
 	"fmt"
 	"math/rand"
-	"net"
 	"net/http"
 	"time"
 
@@ -37,14 +36,28 @@ type Service struct {
 	enableGRPC       bool
 	grpcServer       *grpc.Server
 	grpcServerCalled bool
+
+	httpAddr  string
+	grpcAddr  string
+	kingAddr  string
+	debugAddr string
 }
 
-func Init(name string) *Service {
-	return &Service{
+func Init(name string, opts ...Option) *Service {
+	service := &Service{
 		name:       name,
 		kingRouter: httprouter.New(),
 		httpRouter: httprouter.New(),
+		httpAddr:   envAddr("PORT", ":8080"),
+		grpcAddr:   ":9000",
+		kingAddr:   ":9000",
+		debugAddr:  ":8000",
+	}
+	for _, opt := range opts {
+		opt(service)
 	}
+
+	return service
 }
 
 func (service *Service) ConfigureSentry(dsn string) {
@@ -153,26 +166,41 @@ func (service *Service) Run() {
 		service.httpRouter.GET(fmt.Sprintf("/crons/%s/:job", service.name), service.cronRunner.Handler())
 	}
 
+	// Open all the ports before starting any server to fail early with the
+	// conflicts. King and GRPC listen in :9000 by default.
+	addrs := []listenAddr{
+		{server: "debug", addr: service.debugAddr},
+	}
+	if service.enableKing || service.enableCron {
+		addrs = append(addrs, listenAddr{server: "king", addr: service.kingAddr})
+	}
+	if service.enableRouting || service.enableCron {
+		addrs = append(addrs, listenAddr{server: "routing", addr: service.httpAddr})
+	}
+	if service.enableGRPC {
+		addrs = append(addrs, listenAddr{server: "grpc", addr: service.grpcAddr})
+	}
+	listeners, err := listenAll(addrs)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	if service.enableKing || service.enableCron {
 		go func() {
-			log.Fatal(http.ListenAndServe(":9000", service.kingRouter))
+			log.Fatal(http.Serve(listeners["king"], service.kingRouter))
 		}()
 	}
 
 	if service.enableRouting || service.enableCron {
 		go func() {
-			log.Fatal(http.ListenAndServe(":8080", service.httpRouter))
+			log.Fatal(http.Serve(listeners["routing"], service.httpRouter))
 		}()
 	}
 
 	if service.enableGRPC {
 		go func() {
-			lis, err := net.Listen("tcp", ":9000")
-			if err != nil {
-				log.Fatal(err)
-			}
 			log.Info("GRPC server initialized successfully!")
-			log.Fatal(service.grpcServer.Serve(lis))
+			log.Fatal(service.grpcServer.Serve(listeners["grpc"]))
 		}()
 	}
 
@@ -180,5 +208,5 @@ func (service *Service) Run() {
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) { fmt.Fprintf(w, "%s is ok\n", service.name) })
 
 	log.WithField("name", service.name).Println("Instance initialized successfully!")
-	log.Fatal(http.ListenAndServe(":8000", nil))
+	log.Fatal(http.Serve(listeners["debug"], nil))
 }
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// envAddr reads the port or address from the environment variable, returning the
+// default address if it is not present.
+func envAddr(name, def string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	if !strings.Contains(value, ":") {
+		return ":" + value
+	}
+	return value
+}
+
+type listenAddr struct {
+	server string
+	addr   string
+}
+
+// checkConflicts returns an error if two servers try to bind the same port in
+// a compatible host.
+func checkConflicts(addrs []listenAddr) error {
+	for i, a := range addrs {
+		hostA, portA, err := net.SplitHostPort(a.addr)
+		if err != nil {
+			return fmt.Errorf("invalid address for the %s server: %v", a.server, err)
+		}
+
+		for _, b := range addrs[i+1:] {
+			hostB, portB, err := net.SplitHostPort(b.addr)
+			if err != nil {
+				return fmt.Errorf("invalid address for the %s server: %v", b.server, err)
+			}
+
+			if portA != portB || portA == "0" {
+				continue
+			}
+			if hostA == hostB || isWildcardHost(hostA) || isWildcardHost(hostB) {
+				return fmt.Errorf("the %s server (%s) and the %s server (%s) cannot listen in the same port", a.server, a.addr, b.server, b.addr)
+			}
+		}
+	}
+
+	return nil
+}
+
+func isWildcardHost(host string) bool {
+	return host == "" || host == "0.0.0.0" || host == "::"
+}
+
+// listenAll opens a listener for every address at once. If any of them fails
+// all of them are closed before returning the error.
+func listenAll(addrs []listenAddr) (map[string]net.Listener, error) {
+	if err := checkConflicts(addrs); err != nil {
+		return nil, err
+	}
+
+	listeners := make(map[string]net.Listener)
+	for _, addr := range addrs {
+		listener, err := net.Listen("tcp", addr.addr)
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			return nil, fmt.Errorf("cannot listen in %s for the %s server: %v", addr.addr, addr.server, err)
+		}
+		listeners[addr.server] = listener
+	}
+
+	return listeners, nil
+}
@@ -0,0 +1,36 @@
+package services
+
+// Option configures a service when calling Init.
+type Option func(service *Service)
+
+// WithHTTPAddr changes the address of the routing server. By default it listens
+// in :8080 or in the port of the environment variable PORT if present.
+func WithHTTPAddr(addr string) Option {
+	return func(service *Service) {
+		service.httpAddr = addr
+	}
+}
+
+// WithGRPCAddr changes the address of the GRPC server. By default it listens
+// in :9000.
+func WithGRPCAddr(addr string) Option {
+	return func(service *Service) {
+		service.grpcAddr = addr
+	}
+}
+
+// WithKingAddr changes the address of the King and cron server. By default it
+// listens in :9000, so it should be changed to enable GRPC too.
+func WithKingAddr(addr string) Option {
+	return func(service *Service) {
+		service.kingAddr = addr
+	}
+}
+
+// WithDebugAddr changes the address of the debug server. By default it listens
+// in :8000.
+func WithDebugAddr(addr string) Option {
+	return func(service *Service) {
+		service.debugAddr = addr
+	}
+}
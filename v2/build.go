@@ -0,0 +1,116 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+
+	log "github.com/sirupsen/logrus"
+	"go.opencensus.io/trace"
+)
+
+// Build metadata that should be set when compiling the application with:
+//
+//	go build -ldflags "-X github.com/altipla-consulting/services/v2.GitCommit=$(git rev-parse HEAD) -X github.com/altipla-consulting/services/v2.BuildTime=$(date -u +%FT%TZ)"
+//
+// If they are empty the environment variables GIT_COMMIT and BUILD_TIME will
+// be used instead.
+var (
+	GitCommit string
+	BuildTime string
+)
+
+// BuildInfo contains the metadata of the running binary.
+type BuildInfo struct {
+	Name         string            `json:"name"`
+	Version      string            `json:"version,omitempty"`
+	Commit       string            `json:"commit,omitempty"`
+	BuildTime    string            `json:"buildTime,omitempty"`
+	GoVersion    string            `json:"goVersion"`
+	Dependencies map[string]string `json:"dependencies,omitempty"`
+}
+
+// BuildInfo returns the metadata of the running binary.
+func (service *Service) BuildInfo() BuildInfo {
+	info := newBuildInfo(service.name)
+	if build, ok := debug.ReadBuildInfo(); ok {
+		info.Dependencies = make(map[string]string)
+		for _, dep := range build.Deps {
+			if dep.Replace != nil {
+				dep = dep.Replace
+			}
+			info.Dependencies[dep.Path] = dep.Version
+		}
+	}
+
+	return info
+}
+
+// newBuildInfo returns the metadata of the binary without the dependencies. It
+// can be used without a service, for example in the client connections.
+func newBuildInfo(name string) BuildInfo {
+	info := BuildInfo{
+		Name:      name,
+		Version:   Version(),
+		Commit:    GitCommit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+	if info.Commit == "" {
+		info.Commit = os.Getenv("GIT_COMMIT")
+	}
+	if info.BuildTime == "" {
+		info.BuildTime = os.Getenv("BUILD_TIME")
+	}
+	return info
+}
+
+// release identifies the binary in the Sentry reports.
+func (info BuildInfo) release() string {
+	if info.Version != "" {
+		return info.Version
+	}
+	return info.Commit
+}
+
+// traceAttributes returns the attributes added to every span of the servers
+// and the client connections.
+func (info BuildInfo) traceAttributes() []trace.Attribute {
+	var attrs []trace.Attribute
+	if info.Name != "" {
+		attrs = append(attrs, trace.StringAttribute("app", info.Name))
+	}
+	if info.Version != "" {
+		attrs = append(attrs, trace.StringAttribute("version", info.Version))
+	}
+	if info.Commit != "" {
+		attrs = append(attrs, trace.StringAttribute("commit", info.Commit))
+	}
+	return attrs
+}
+
+func (info BuildInfo) logFields() log.Fields {
+	fields := log.Fields{
+		"name":       info.Name,
+		"go-version": info.GoVersion,
+	}
+	if info.Version != "" {
+		fields["version"] = info.Version
+	}
+	if info.Commit != "" {
+		fields["commit"] = info.Commit
+	}
+	if info.BuildTime != "" {
+		fields["build-time"] = info.BuildTime
+	}
+	return fields
+}
+
+func (service *Service) versionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(service.BuildInfo()); err != nil {
+		log.WithField("error", err.Error()).Error("Cannot encode build info")
+	}
+}
@@ -0,0 +1,423 @@
+// Package config loads the configuration of a service from environment variables
+// and optional files into a tagged struct.
+//
+// Each field of the struct can have the following tags:
+//
+//	env:"NAME"         Environment variable that overrides the value.
+//	default:"value"    Value used if nothing else provides one.
+//	required:"true"    Fails if the field is empty after loading everything.
+//	                   Booleans should be set explicitly by the file or the
+//	                   environment, even if it is to false.
+//
+// Values are applied in order: defaults, file and environment variables. The
+// supported field types are strings, booleans, integers, floats, time.Duration,
+// slices of strings separated by commas and nested structs. The values of the
+// files are parsed like the environment variables, for example durations are
+// written as "5s". The keys of the files are read from the json and yaml tags.
+//
+// If the struct implements the Validator interface it will be called after
+// loading all the values. All the errors are reported together.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Validator can be implemented by the configuration struct to check the loaded
+// values before returning them.
+type Validator interface {
+	Validate() error
+}
+
+// Option configures the loading of the configuration.
+type Option func(loader *loader)
+
+// WithFile reads the values from a JSON or YAML file, depending on the extension.
+// If the file does not exist it will be ignored, to allow mounting it only in some
+// environments.
+func WithFile(path string) Option {
+	return func(loader *loader) {
+		loader.file = path
+	}
+}
+
+// WithLookup changes the function used to read the environment variables. It
+// is useful in tests to avoid modifying the real environment.
+func WithLookup(lookup func(name string) (string, bool)) Option {
+	return func(loader *loader) {
+		loader.lookup = lookup
+	}
+}
+
+// Errors is the list of problems found loading the configuration.
+type Errors []error
+
+// Error implements the error interface.
+func (errs Errors) Error() string {
+	var msgs []string
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return "invalid configuration: " + strings.Join(msgs, "; ")
+}
+
+type loader struct {
+	file   string
+	lookup func(name string) (string, bool)
+	errs   Errors
+
+	// set has the path of the fields with a value in the file or the environment.
+	set map[string]bool
+}
+
+// Load fills the struct pointed by dest with the configuration values.
+func Load(dest interface{}, opts ...Option) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return errors.Errorf("config should be a pointer to a struct, got %T", dest)
+	}
+
+	loader := &loader{
+		lookup: os.LookupEnv,
+		set:    make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(loader)
+	}
+
+	loader.walk(v.Elem(), "", "default")
+	if values, format := loader.readFile(); values != nil {
+		loader.fill(v.Elem(), "", values, format)
+	}
+	loader.walk(v.Elem(), "", "env")
+	loader.walk(v.Elem(), "", "required")
+	if validator, ok := dest.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			loader.errs = append(loader.errs, err)
+		}
+	}
+	if len(loader.errs) > 0 {
+		return loader.errs
+	}
+
+	return nil
+}
+
+// MustLoad fills the struct pointed by dest with the configuration values. If
+// there is any problem it logs all of them and exits the program.
+func MustLoad(dest interface{}, opts ...Option) {
+	if err := Load(dest, opts...); err != nil {
+		if errs, ok := err.(Errors); ok {
+			for _, err := range errs {
+				log.WithField("error", err.Error()).Error("Invalid configuration")
+			}
+		}
+		log.Fatal(err)
+	}
+}
+
+// readFile decodes the file in a tree of values and returns it with the format
+// of the file. It returns nil if there is no file or it cannot be read.
+func (loader *loader) readFile() (map[string]interface{}, string) {
+	if loader.file == "" {
+		return nil, ""
+	}
+
+	content, err := ioutil.ReadFile(loader.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			loader.errs = append(loader.errs, errors.Annotatef(err, "cannot read config file %s", loader.file))
+		}
+		return nil, ""
+	}
+
+	var values map[string]interface{}
+	switch format := filepath.Ext(loader.file); format {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber()
+		if err := decoder.Decode(&values); err != nil {
+			loader.errs = append(loader.errs, errors.Annotatef(err, "cannot read config file %s", loader.file))
+			return nil, ""
+		}
+		return values, format
+
+	case ".yaml", ".yml":
+		var raw map[interface{}]interface{}
+		if err := yaml.Unmarshal(content, &raw); err != nil {
+			loader.errs = append(loader.errs, errors.Annotatef(err, "cannot read config file %s", loader.file))
+			return nil, ""
+		}
+		return yamlValues(raw), ".yaml"
+
+	default:
+		loader.errs = append(loader.errs, errors.Errorf("unknown config file format: %s", loader.file))
+		return nil, ""
+	}
+}
+
+// yamlValues converts the maps of YAML, that can have keys of any type, to the
+// same tree of values of JSON.
+func yamlValues(raw map[interface{}]interface{}) map[string]interface{} {
+	values := make(map[string]interface{})
+	for k, v := range raw {
+		if nested, ok := v.(map[interface{}]interface{}); ok {
+			v = yamlValues(nested)
+		}
+		values[fmt.Sprint(k)] = v
+	}
+	return values
+}
+
+// fill applies the values of the file to the fields of the struct. Scalar values
+// are parsed from their text like the environment variables.
+func (loader *loader) fill(v reflect.Value, prefix string, values map[string]interface{}, format string) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := v.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		path := prefix + field.Name
+
+		key, ok := fileKey(field, format)
+		if !ok {
+			continue
+		}
+		if isStruct(field.Type) && field.Anonymous && key == "" {
+			loader.fill(value, path+".", values, format)
+			continue
+		}
+		raw, ok := lookupKey(values, key, format)
+		if !ok || raw == nil {
+			continue
+		}
+
+		if isStruct(field.Type) {
+			nested, ok := raw.(map[string]interface{})
+			if !ok {
+				loader.errs = append(loader.errs, errors.Errorf("invalid value for field %s in config file %s: expected an object", path, loader.file))
+				continue
+			}
+			loader.fill(value, path+".", nested, format)
+			continue
+		}
+
+		text, err := fileText(raw)
+		if err == nil {
+			err = setValue(value, text)
+		}
+		if err != nil {
+			loader.errs = append(loader.errs, errors.Annotatef(err, "invalid value for field %s in config file %s", path, loader.file))
+			continue
+		}
+		loader.set[path] = true
+	}
+}
+
+// fileKey returns the key of the field in the file. Anonymous structs without
+// a name in the tag return an empty key, their fields are read from the same
+// level like the JSON and YAML packages do.
+func fileKey(field reflect.StructField, format string) (string, bool) {
+	tag := "json"
+	if format != ".json" {
+		tag = "yaml"
+	}
+	name := strings.Split(field.Tag.Get(tag), ",")[0]
+	if name == "-" {
+		return "", false
+	}
+	if name != "" {
+		return name, true
+	}
+	if field.Anonymous {
+		return "", true
+	}
+	if format != ".json" {
+		return strings.ToLower(field.Name), true
+	}
+	return field.Name, true
+}
+
+// lookupKey finds the key in the values. JSON keys match without taking into
+// account the case like the encoding/json package.
+func lookupKey(values map[string]interface{}, key, format string) (interface{}, bool) {
+	if raw, ok := values[key]; ok {
+		return raw, true
+	}
+	if format == ".json" {
+		for k, raw := range values {
+			if strings.EqualFold(k, key) {
+				return raw, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// fileText returns the text of a scalar value of the file. Lists are joined with
+// commas like the environment variables.
+func fileText(raw interface{}) (string, error) {
+	switch raw := raw.(type) {
+	case map[string]interface{}:
+		return "", errors.New("unexpected object")
+
+	case []interface{}:
+		var items []string
+		for _, item := range raw {
+			text, err := fileText(item)
+			if err != nil {
+				return "", errors.Trace(err)
+			}
+			items = append(items, text)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return fmt.Sprint(raw), nil
+}
+
+func isStruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t != reflect.TypeOf(time.Time{})
+}
+
+// walk applies one of the stages to every field of the struct.
+func (loader *loader) walk(v reflect.Value, prefix, stage string) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := v.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		path := prefix + field.Name
+
+		if isStruct(field.Type) {
+			loader.walk(value, path+".", stage)
+			continue
+		}
+
+		switch stage {
+		case "default":
+			def, ok := field.Tag.Lookup("default")
+			if !ok {
+				continue
+			}
+			if err := setValue(value, def); err != nil {
+				loader.errs = append(loader.errs, errors.Annotatef(err, "invalid default for field %s", field.Name))
+			}
+
+		case "env":
+			name := field.Tag.Get("env")
+			if name == "" {
+				continue
+			}
+			env, ok := loader.lookup(name)
+			if !ok {
+				continue
+			}
+			if err := setValue(value, env); err != nil {
+				loader.errs = append(loader.errs, errors.Annotatef(err, "invalid value for environment variable %s", name))
+				continue
+			}
+			loader.set[path] = true
+
+		case "required":
+			if field.Tag.Get("required") != "true" || loader.set[path] {
+				continue
+			}
+			// A false boolean cannot be told apart from a missing one, it should
+			// be set explicitly.
+			if value.Kind() != reflect.Bool && !isZero(value) {
+				continue
+			}
+			if name := field.Tag.Get("env"); name != "" {
+				loader.errs = append(loader.errs, errors.Errorf("required field %s is empty, set the environment variable %s", field.Name, name))
+			} else {
+				loader.errs = append(loader.errs, errors.Errorf("required field %s is empty", field.Name))
+			}
+		}
+	}
+}
+
+func setValue(value reflect.Value, raw string) error {
+	if value.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		value.SetInt(int64(d))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		value.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, value.Type().Bits())
+		if err != nil {
+			return errors.Trace(err)
+		}
+		value.SetInt(n)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, value.Type().Bits())
+		if err != nil {
+			return errors.Trace(err)
+		}
+		value.SetUint(n)
+
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(raw, value.Type().Bits())
+		if err != nil {
+			return errors.Trace(err)
+		}
+		value.SetFloat(n)
+
+	case reflect.Slice:
+		if value.Type().Elem().Kind() != reflect.String {
+			return errors.Errorf("unsupported slice type %s", value.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		value.Set(reflect.ValueOf(items).Convert(value.Type()))
+
+	default:
+		return errors.Errorf("unsupported type %s", value.Type())
+	}
+
+	return nil
+}
+
+func isZero(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	}
+	return reflect.DeepEqual(value.Interface(), reflect.Zero(value.Type()).Interface())
+}
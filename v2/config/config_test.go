@@ -0,0 +1,178 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type testConfig struct {
+	SentryDSN     string        `env:"SENTRY_DSN" yaml:"sentry-dsn" json:"sentryDSN"`
+	GoogleProject string        `env:"GOOGLE_PROJECT" required:"true" yaml:"google-project" json:"googleProject"`
+	Workers       int           `env:"WORKERS" default:"4" yaml:"workers" json:"workers"`
+	Timeout       time.Duration `env:"TIMEOUT" default:"5s" yaml:"timeout" json:"timeout"`
+	Features      []string      `env:"FEATURES" yaml:"features" json:"features"`
+	Debug         bool          `env:"DEBUG" yaml:"debug" json:"debug"`
+}
+
+func lookupMap(values map[string]string) Option {
+	return WithLookup(func(name string) (string, bool) {
+		value, ok := values[name]
+		return value, ok
+	})
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cnf := new(testConfig)
+	require.NoError(t, Load(cnf, lookupMap(map[string]string{"GOOGLE_PROJECT": "foo"})))
+
+	require.Equal(t, cnf.GoogleProject, "foo")
+	require.Equal(t, cnf.Workers, 4)
+	require.Equal(t, cnf.Timeout, 5*time.Second)
+}
+
+func TestLoadEnv(t *testing.T) {
+	cnf := new(testConfig)
+	env := map[string]string{
+		"GOOGLE_PROJECT": "foo",
+		"WORKERS":        "8",
+		"TIMEOUT":        "1m",
+		"FEATURES":       "foo, bar",
+		"DEBUG":          "true",
+	}
+	require.NoError(t, Load(cnf, lookupMap(env)))
+
+	require.Equal(t, cnf.Workers, 8)
+	require.Equal(t, cnf.Timeout, time.Minute)
+	require.Equal(t, cnf.Features, []string{"foo", "bar"})
+	require.True(t, cnf.Debug)
+}
+
+func TestLoadReportsAllErrors(t *testing.T) {
+	cnf := new(testConfig)
+	err := Load(cnf, lookupMap(map[string]string{"WORKERS": "foo", "DEBUG": "bar"}))
+	require.Error(t, err)
+
+	errs, ok := err.(Errors)
+	require.True(t, ok)
+	require.Len(t, errs, 3)
+}
+
+func TestLoadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "config.yaml")
+	require.NoError(t, ioutil.WriteFile(filename, []byte("google-project: foo\nworkers: 2\n"), 0600))
+
+	cnf := new(testConfig)
+	require.NoError(t, Load(cnf, WithFile(filename), lookupMap(map[string]string{"WORKERS": "3"})))
+
+	require.Equal(t, cnf.GoogleProject, "foo")
+	require.Equal(t, cnf.Workers, 3)
+	require.Equal(t, cnf.Timeout, 5*time.Second)
+}
+
+func TestLoadJSONFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "config.json")
+	content := `{"googleProject": "foo", "workers": 2, "timeout": "90s", "features": ["foo", "bar"], "debug": true}`
+	require.NoError(t, ioutil.WriteFile(filename, []byte(content), 0600))
+
+	cnf := new(testConfig)
+	require.NoError(t, Load(cnf, WithFile(filename), lookupMap(nil)))
+
+	require.Equal(t, cnf.GoogleProject, "foo")
+	require.Equal(t, cnf.Workers, 2)
+	require.Equal(t, cnf.Timeout, 90*time.Second)
+	require.Equal(t, cnf.Features, []string{"foo", "bar"})
+	require.True(t, cnf.Debug)
+}
+
+type nestedConfig struct {
+	Database struct {
+		Address string        `yaml:"address" json:"address" required:"true"`
+		Timeout time.Duration `yaml:"timeout" json:"timeout"`
+	} `yaml:"database" json:"database"`
+}
+
+func TestLoadNestedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "config.yaml")
+	require.NoError(t, ioutil.WriteFile(filename, []byte("database:\n  address: localhost:5432\n  timeout: 2s\n"), 0600))
+
+	cnf := new(nestedConfig)
+	require.NoError(t, Load(cnf, WithFile(filename), lookupMap(nil)))
+
+	require.Equal(t, cnf.Database.Address, "localhost:5432")
+	require.Equal(t, cnf.Database.Timeout, 2*time.Second)
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "config.json")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(`{"workers": "foo", "timeout": "bar"}`), 0600))
+
+	// The errors of the file are reported with the rest of them.
+	cnf := new(testConfig)
+	err = Load(cnf, WithFile(filename), lookupMap(map[string]string{"DEBUG": "baz"}))
+	errs, ok := err.(Errors)
+	require.True(t, ok)
+	require.Len(t, errs, 4)
+
+	require.NoError(t, ioutil.WriteFile(filename, []byte(`{"workers": `), 0600))
+	err = Load(cnf, WithFile(filename), lookupMap(nil))
+	errs, ok = err.(Errors)
+	require.True(t, ok)
+	require.Len(t, errs, 2)
+}
+
+type requiredBoolConfig struct {
+	Enabled bool `env:"ENABLED" required:"true"`
+}
+
+func TestLoadRequiredBool(t *testing.T) {
+	require.Error(t, Load(new(requiredBoolConfig), lookupMap(nil)))
+
+	cnf := new(requiredBoolConfig)
+	require.NoError(t, Load(cnf, lookupMap(map[string]string{"ENABLED": "false"})))
+	require.False(t, cnf.Enabled)
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	cnf := new(testConfig)
+	require.NoError(t, Load(cnf, WithFile("/not-exists/config.json"), lookupMap(map[string]string{"GOOGLE_PROJECT": "foo"})))
+}
+
+type validatedConfig struct {
+	Port int `env:"PORT" default:"8080"`
+}
+
+func (cnf *validatedConfig) Validate() error {
+	if cnf.Port > 65535 {
+		return errors.Errorf("invalid port: %d", cnf.Port)
+	}
+	return nil
+}
+
+func TestLoadValidate(t *testing.T) {
+	cnf := new(validatedConfig)
+	err := Load(cnf, lookupMap(map[string]string{"PORT": "70000"}))
+
+	require.EqualError(t, err, "invalid configuration: invalid port: 70000")
+}
@@ -0,0 +1,218 @@
+// Package discovery declares the remote GRPC services an application calls and
+// resolves their addresses in each environment.
+//
+// Each remote service is registered once, usually in a package variable:
+//
+//	var Users = discovery.Register(discovery.Service{
+//		Name: "users",
+//		Addresses: map[services.Env]string{
+//			services.EnvLocal:      "users",
+//			services.EnvProduction: "users.default.svc.cluster.local",
+//		},
+//	})
+//
+// The address can be overridden with an environment variable named after the
+// service, for example USERS_ADDR=localhost:9001 or, to use several local
+// replicas, USERS_ADDR=static:///localhost:9001,localhost:9002. Connections are opened with
+// DialService and shared by all the callers:
+//
+//	conn, err := discovery.DialService(Users)
+//
+// They are closed when the application stops if the service is created with:
+//
+//	service := services.Init("app", discovery.CloseOnShutdown())
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/altipla-consulting/services/v2"
+	"github.com/juju/errors"
+	"google.golang.org/grpc"
+)
+
+// DefaultPort is the port used when the address of a service does not have one.
+// It is the default port of the GRPC server of this package.
+const DefaultPort = 9000
+
+// Service declares a remote GRPC service.
+type Service struct {
+	// Name identifies the service. It is the host of the service when there is no
+	// address for the current environment.
+	Name string
+
+	// Port is added to the addresses without one. By default DefaultPort.
+	Port int
+
+	// Addresses has the host, with an optional port, of the service in each
+	// environment. The test environment uses the local address and the preview
+	// one the staging address if they do not have their own.
+	Addresses map[services.Env]string
+}
+
+// Endpoint is the handle of a registered service returned by Register.
+type Endpoint struct {
+	name string
+}
+
+// Name returns the name of the registered service.
+func (endpoint Endpoint) Name() string {
+	return endpoint.name
+}
+
+type registry struct {
+	mu       sync.Mutex
+	services map[string]Service
+	conns    map[string]*grpc.ClientConn
+}
+
+func newRegistry() *registry {
+	return &registry{
+		services: make(map[string]Service),
+		conns:    make(map[string]*grpc.ClientConn),
+	}
+}
+
+var defaultRegistry = newRegistry()
+
+// Register declares a remote service and returns the endpoint to use with Resolve
+// and DialService. It panics if the name is empty or it was already registered.
+func Register(service Service) Endpoint {
+	return defaultRegistry.register(service)
+}
+
+// Resolve returns the address of a registered service in the current environment.
+func Resolve(endpoint Endpoint) (services.Endpoint, error) {
+	return defaultRegistry.resolve(endpoint)
+}
+
+// DialService returns the shared connection to a registered service, opening it
+// the first time it is needed. The options are only used when opening it.
+func DialService(endpoint Endpoint, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	return defaultRegistry.dial(endpoint, opts...)
+}
+
+// Close closes all the connections opened with DialService. It can be used
+// directly as a shutdown hook of the service.
+func Close(ctx context.Context) error {
+	return defaultRegistry.close()
+}
+
+// CloseOnShutdown registers Close as a shutdown hook of the service. Hooks run
+// in the reverse order, so the connections are closed after the rest of hooks
+// registered later finish using them.
+func CloseOnShutdown() services.Option {
+	return func(service *services.Service) {
+		service.OnShutdown(Close)
+	}
+}
+
+func (r *registry) register(service Service) Endpoint {
+	if service.Name == "" {
+		panic("discovery: service name is required")
+	}
+	if service.Port == 0 {
+		service.Port = DefaultPort
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.services[service.Name]; ok {
+		panic(fmt.Sprintf("discovery: service %q registered twice", service.Name))
+	}
+	r.services[service.Name] = service
+
+	return Endpoint{name: service.Name}
+}
+
+func (r *registry) resolve(endpoint Endpoint) (services.Endpoint, error) {
+	r.mu.Lock()
+	service, ok := r.services[endpoint.name]
+	r.mu.Unlock()
+	if !ok {
+		return "", errors.NotFoundf("service %q", endpoint.name)
+	}
+
+	addr := os.Getenv(envName(service.Name))
+	if addr == "" {
+		addr = service.address(services.Environment())
+	}
+	// Targets with a scheme, like static:///localhost:9001,localhost:9002, are
+	// sent to services.Dial as they are.
+	if strings.Contains(addr, "://") {
+		return services.Endpoint(addr), nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, fmt.Sprintf("%d", service.Port))
+	}
+
+	return services.Endpoint(addr), nil
+}
+
+func (service Service) address(env services.Env) string {
+	if addr, ok := service.Addresses[env]; ok {
+		return addr
+	}
+	switch env {
+	case services.EnvTest:
+		if addr, ok := service.Addresses[services.EnvLocal]; ok {
+			return addr
+		}
+	case services.EnvPreview:
+		if addr, ok := service.Addresses[services.EnvStaging]; ok {
+			return addr
+		}
+	}
+	return service.Name
+}
+
+// envName returns the environment variable that overrides the address of the
+// service, for example USERS_ADDR for "users".
+func envName(name string) string {
+	name = strings.ToUpper(name)
+	name = strings.NewReplacer("-", "_", ".", "_").Replace(name)
+	return name + "_ADDR"
+}
+
+func (r *registry) dial(endpoint Endpoint, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	target, err := r.resolve(endpoint)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if conn, ok := r.conns[endpoint.name]; ok {
+		return conn, nil
+	}
+
+	conn, err := services.Dial(target, opts...)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot dial service %q", endpoint.name)
+	}
+	r.conns[endpoint.name] = conn
+
+	return conn, nil
+}
+
+func (r *registry) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var first error
+	for name, conn := range r.conns {
+		if err := conn.Close(); err != nil && first == nil {
+			first = errors.Annotatef(err, "cannot close service %q", name)
+		}
+	}
+	r.conns = make(map[string]*grpc.ClientConn)
+
+	return first
+}
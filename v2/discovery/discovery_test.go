@@ -0,0 +1,108 @@
+package discovery
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/altipla-consulting/services/v2"
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func TestResolve(t *testing.T) {
+	r := newRegistry()
+	users := r.register(Service{
+		Name: "users",
+		Addresses: map[services.Env]string{
+			services.EnvLocal:      "users-local",
+			services.EnvStaging:    "users-staging",
+			services.EnvProduction: "users.default.svc.cluster.local:9001",
+		},
+	})
+	billing := r.register(Service{Name: "billing", Port: 9002})
+	require.Equal(t, users.Name(), "users")
+
+	// The tests use the local address.
+	endpoint, err := r.resolve(users)
+	require.NoError(t, err)
+	require.EqualValues(t, endpoint, "users-local:9000")
+
+	endpoint, err = r.resolve(billing)
+	require.NoError(t, err)
+	require.EqualValues(t, endpoint, "billing:9002")
+
+	service := r.services["users"]
+	require.Equal(t, service.address(services.EnvProduction), "users.default.svc.cluster.local:9001")
+	require.Equal(t, service.address(services.EnvStaging), "users-staging")
+	require.Equal(t, service.address(services.EnvPreview), "users-staging")
+	require.Equal(t, r.services["billing"].address(services.EnvPreview), "billing")
+}
+
+func TestResolveOverride(t *testing.T) {
+	r := newRegistry()
+	events := r.register(Service{Name: "user-events"})
+
+	os.Setenv("USER_EVENTS_ADDR", "localhost:9100")
+	defer os.Unsetenv("USER_EVENTS_ADDR")
+
+	endpoint, err := r.resolve(events)
+	require.NoError(t, err)
+	require.EqualValues(t, endpoint, "localhost:9100")
+
+	os.Setenv("USER_EVENTS_ADDR", "static:///localhost:9100,localhost:9101")
+	endpoint, err = r.resolve(events)
+	require.NoError(t, err)
+	require.EqualValues(t, endpoint, "static:///localhost:9100,localhost:9101")
+}
+
+func TestResolveNotRegistered(t *testing.T) {
+	_, err := newRegistry().resolve(Endpoint{name: "foo"})
+	require.True(t, errors.IsNotFound(err))
+
+	_, err = newRegistry().resolve(Endpoint{})
+	require.True(t, errors.IsNotFound(err))
+}
+
+func TestRegisterTwice(t *testing.T) {
+	r := newRegistry()
+	r.register(Service{Name: "users"})
+
+	require.Panics(t, func() { r.register(Service{Name: "users"}) })
+}
+
+func TestDialServiceCache(t *testing.T) {
+	r := newRegistry()
+	users := r.register(Service{Name: "users"})
+
+	conn, err := r.dial(users, grpc.WithInsecure())
+	require.NoError(t, err)
+	other, err := r.dial(users, grpc.WithInsecure())
+	require.NoError(t, err)
+	require.True(t, conn == other)
+
+	require.NoError(t, r.close())
+	require.Empty(t, r.conns)
+
+	// The shared registry can be closed as a shutdown hook.
+	require.NoError(t, Close(context.Background()))
+}
+
+func TestCloseOnShutdown(t *testing.T) {
+	previous := defaultRegistry
+	defaultRegistry = newRegistry()
+	defer func() { defaultRegistry = previous }()
+
+	endpoint := Register(Service{Name: "close-on-shutdown"})
+	_, err := DialService(endpoint, grpc.WithInsecure())
+	require.NoError(t, err)
+	require.Contains(t, defaultRegistry.conns, "close-on-shutdown")
+
+	service := services.Init("test", services.WithDebugAddr("127.0.0.1:0"), CloseOnShutdown())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, service.RunContext(ctx))
+
+	require.Empty(t, defaultRegistry.conns)
+}
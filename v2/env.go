@@ -1,13 +1,81 @@
 package services
 
 import (
+	"flag"
 	"os"
+	"strings"
+	"sync"
+
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Env is the kind of environment where the application is running.
+type Env string
+
+const (
+	// EnvLocal is a local debug environment in the machine of a developer.
+	EnvLocal = Env("local")
+
+	// EnvTest is a run of the tests of the application.
+	EnvTest = Env("test")
+
+	// EnvStaging is a Kubernetes container that is not serving real users.
+	EnvStaging = Env("staging")
+
+	// EnvPreview is a Kubernetes container deployed temporarily to review a change.
+	// It does not serve real users and behaves like staging.
+	EnvPreview = Env("preview")
+
+	// EnvProduction is a production Kubernetes container.
+	EnvProduction = Env("production")
 )
 
-// IsLocal returns true if we are running inside a local debug environment instead
-// of a production Kubernetes container. It dependes on Version() working correctly.
+// Environment returns the environment where the application is running. It reads
+// the environment variable ENVIRONMENT if present. Otherwise it detects if we are
+// running the tests or falls back to Version(): empty means local and any other
+// value production. Unknown values in ENVIRONMENT are logged and ignored.
+func Environment() Env {
+	if value := os.Getenv("ENVIRONMENT"); value != "" {
+		env, err := ParseEnv(value)
+		if err == nil {
+			return env
+		}
+		unknownEnvOnce.Do(func() {
+			log.WithField("environment", value).Warning("Unknown environment in the ENVIRONMENT variable, detecting it instead")
+		})
+	}
+
+	if flag.Lookup("test.v") != nil || strings.HasSuffix(os.Args[0], ".test") {
+		return EnvTest
+	}
+	if Version() == "" {
+		return EnvLocal
+	}
+	return EnvProduction
+}
+
+var unknownEnvOnce sync.Once
+
+// ParseEnv validates the name of an environment.
+func ParseEnv(value string) (Env, error) {
+	switch env := Env(value); env {
+	case EnvLocal, EnvTest, EnvStaging, EnvPreview, EnvProduction:
+		return env, nil
+	}
+	return "", errors.NotValidf("environment %q", value)
+}
+
+// IsLocal returns true if we are running inside a local debug environment or the
+// tests instead of a Kubernetes container.
 func IsLocal() bool {
-	return Version() == ""
+	env := Environment()
+	return env == EnvLocal || env == EnvTest
+}
+
+// IsProduction returns true if we are running in a production container.
+func IsProduction() bool {
+	return Environment() == EnvProduction
 }
 
 // Version returns the environment variable VERSION. In development it should be empty.
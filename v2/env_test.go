@@ -0,0 +1,51 @@
+package services
+
+import (
+	"os"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvironmentDetectsTests(t *testing.T) {
+	os.Setenv("VERSION", "foo")
+	defer os.Unsetenv("VERSION")
+
+	require.Equal(t, Environment(), EnvTest)
+	require.True(t, IsLocal())
+}
+
+func TestEnvironmentVariable(t *testing.T) {
+	os.Setenv("ENVIRONMENT", "staging")
+	defer os.Unsetenv("ENVIRONMENT")
+
+	require.Equal(t, Environment(), EnvStaging)
+	require.False(t, IsLocal())
+	require.False(t, IsProduction())
+}
+
+func TestEnvironmentUnknown(t *testing.T) {
+	os.Setenv("ENVIRONMENT", "prod")
+	defer os.Unsetenv("ENVIRONMENT")
+
+	require.Equal(t, Environment(), EnvTest)
+}
+
+func TestEnvironmentPreview(t *testing.T) {
+	os.Setenv("ENVIRONMENT", "preview")
+	defer os.Unsetenv("ENVIRONMENT")
+
+	require.Equal(t, Environment(), EnvPreview)
+	require.False(t, IsLocal())
+	require.False(t, IsProduction())
+}
+
+func TestParseEnv(t *testing.T) {
+	env, err := ParseEnv("production")
+	require.NoError(t, err)
+	require.Equal(t, env, EnvProduction)
+
+	_, err = ParseEnv("prod")
+	require.True(t, errors.IsNotValid(err))
+}
@@ -1,19 +1,28 @@
 module github.com/altipla-consulting/services/v2
 
+go 1.14
+
 require (
 	cloud.google.com/go v0.28.0
 	contrib.go.opencensus.io/exporter/stackdriver v0.6.0
 	github.com/altipla-consulting/routing v1.0.2
 	github.com/altipla-consulting/sentry v0.3.1
 	github.com/aws/aws-sdk-go v1.15.53 // indirect
+	github.com/certifi/gocertifi v0.0.0-20180905225744-ee1a9a0726d2 // indirect
+	github.com/getsentry/raven-go v0.0.0-20180903072508-084a9de9eb03
 	github.com/google/pprof v0.0.0-20180926163344-782e5fd74720 // indirect
 	github.com/googleapis/gax-go v2.0.0+incompatible // indirect
 	github.com/juju/errors v0.0.0-20180806074554-22422dad46e1
 	github.com/julienschmidt/httprouter v0.0.0-20180715161854-348b672cd90d
+	github.com/pkg/errors v0.8.0 // indirect
+	github.com/prometheus/client_golang v0.9.0
 	github.com/sirupsen/logrus v1.1.0
 	github.com/stretchr/testify v1.2.2
 	go.opencensus.io v0.17.0
-	golang.org/x/net v0.0.0-20180926154720-4dfa2610cdf3
+	golang.org/x/net v0.0.0-20190311183353-d8887717615a
+	golang.org/x/sync v0.0.0-20190423024810-112230192c58
 	google.golang.org/api v0.0.0-20180929000454-5da02d31af7d // indirect
-	google.golang.org/grpc v1.15.0
+	google.golang.org/grpc v1.28.0
+	gopkg.in/square/go-jose.v2 v2.5.1
+	gopkg.in/yaml.v2 v2.2.1
 )
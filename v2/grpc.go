@@ -7,7 +7,6 @@ import (
 	"github.com/juju/errors"
 	log "github.com/sirupsen/logrus"
 	"go.opencensus.io/plugin/ocgrpc"
-	"go.opencensus.io/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -16,35 +15,74 @@ import (
 // Endpoint is a simple string with the host and port of the remote GRPC
 // service. We use a custom type to avoid using grpc.Dial without noticing the bug.
 //
-// This needs a "discovery" package with the full list of remote addresses that
-// use this type instead of string and never using the direct address. That way
-// if you use grpc.Dial it will report the compilation error inmediatly.
+// Declare the remote services in the discovery package and resolve their
+// addresses from there instead of writing them directly. That way if you use
+// grpc.Dial it will report the compilation error inmediatly.
 type Endpoint string
 
+// GRPCOption configures the GRPC server of the service.
+type GRPCOption func(service *Service)
+
+// WithUnaryInterceptors adds interceptors to the GRPC server. They run in order
+// after the built-in ones, so they receive the authenticated context and their
+// errors are logged and reported.
+func WithUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) GRPCOption {
+	return func(service *Service) {
+		service.grpcUnary = append(service.grpcUnary, interceptors...)
+	}
+}
+
+// WithStreamInterceptors adds stream interceptors to the GRPC server. They run in
+// order after the built-in ones.
+func WithStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) GRPCOption {
+	return func(service *Service) {
+		service.grpcStream = append(service.grpcStream, interceptors...)
+	}
+}
+
+// WithServerOptions adds raw options to the GRPC server, like grpc.MaxRecvMsgSize
+// or grpc.KeepaliveEnforcementPolicy. Use WithUnaryInterceptors and
+// WithStreamInterceptors instead of grpc.UnaryInterceptor and grpc.StreamInterceptor:
+// those run before the built-in interceptors, without the authentication, and
+// their failures are not logged nor reported.
+func WithServerOptions(opts ...grpc.ServerOption) GRPCOption {
+	return func(service *Service) {
+		service.grpcOpts = append(service.grpcOpts, opts...)
+	}
+}
+
 // Dial helps to open a connection to a remote GRPC server with tracing support and
-// other goodies configured in this package.
+// other goodies configured in this package. The targets are balanced with
+// round_robin between all their addresses, the calls have a default deadline of
+// 30 seconds and the failures are logged.
 func Dial(target Endpoint, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
-	opts = append(opts, grpc.WithStatsHandler(new(ocgrpc.ClientHandler)))
-	return grpc.Dial(string(target), opts...)
-}
+	address, resolverOpts, err := dialTarget(target)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 
-func grpcUnaryErrorLogger(enableTracer bool, serviceName, dsn string) grpc.UnaryServerInterceptor {
-	var client *sentry.Client
-	if dsn != "" {
-		client = sentry.NewClient(dsn)
+	defaults := []grpc.DialOption{
+		grpc.WithDefaultServiceConfig(defaultServiceConfig),
+		grpc.WithChainUnaryInterceptor(unaryClientInterceptor(defaultCallTimeout, retryBackoff)),
+		grpc.WithChainStreamInterceptor(streamClientInterceptor()),
 	}
+	opts = append(append(defaults, resolverOpts...), opts...)
+	opts = append(opts, grpc.WithStatsHandler(&clientTraceHandler{
+		ClientHandler: new(ocgrpc.ClientHandler),
+		build:         newBuildInfo(""),
+	}))
 
-	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		ctx = sentry.WithContextRPC(ctx, serviceName, info.FullMethod)
+	return grpc.Dial(address, opts...)
+}
 
-		if enableTracer {
-			span := trace.FromContext(ctx)
-			span.AddAttributes(trace.StringAttribute("app", serviceName))
-		}
+func grpcUnaryErrorLogger(build BuildInfo, reporter *sentryReporter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx = sentry.WithContextRPC(ctx, build.Name, info.FullMethod)
+		ctx = withRPCCaller(ctx, build.Name, info.FullMethod)
 
 		resp, err := handler(ctx, req)
 		if err != nil {
-			logError(ctx, client, err)
+			logError(ctx, reporter, build, info.FullMethod, err)
 		}
 
 		return resp, err
@@ -59,53 +97,68 @@ type wrappedStream struct {
 func (stream *wrappedStream) Context() context.Context {
 	ctx := stream.ServerStream.Context()
 	ctx = sentry.WithContextRPC(ctx, stream.serviceName, stream.method)
+	ctx = withRPCCaller(ctx, stream.serviceName, stream.method)
 
 	return ctx
 }
 
-func grpcStreamErrorLogger(serviceName, dsn string) grpc.StreamServerInterceptor {
-	var client *sentry.Client
-	if dsn != "" {
-		client = sentry.NewClient(dsn)
-	}
-
+func grpcStreamErrorLogger(build BuildInfo, reporter *sentryReporter) grpc.StreamServerInterceptor {
 	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
 		wrapped := &wrappedStream{
 			ServerStream: stream,
-			serviceName:  serviceName,
+			serviceName:  build.Name,
 			method:       info.FullMethod,
 		}
 		err := handler(srv, wrapped)
 		if err != nil {
-			logError(wrapped.Context(), client, err)
+			logError(wrapped.Context(), reporter, build, info.FullMethod, err)
 		}
 
 		return err
 	}
 }
 
-func logError(ctx context.Context, client *sentry.Client, err error) {
+func logError(ctx context.Context, reporter *sentryReporter, build BuildInfo, method string, err error) {
+	logger := log.WithFields(log.Fields{
+		"version": build.Version,
+		"commit":  build.Commit,
+	}).WithFields(incomingCallerFields(ctx))
+
 	grpcerr, ok := status.FromError(err)
 	if ok {
 		// Always log the GRPC errors.
-		log.WithFields(log.Fields{
+		logger.WithFields(log.Fields{
 			"code":    grpcerr.Code().String(),
 			"message": grpcerr.Message(),
 		}).Error("GRPC call failed")
 
 		// Do not notify those status codes.
-		switch grpcerr.Code() {
-		case codes.InvalidArgument, codes.NotFound, codes.AlreadyExists, codes.FailedPrecondition, codes.Aborted, codes.Unimplemented, codes.Canceled:
+		if isExpectedCode(grpcerr.Code()) {
 			return
 		}
 	} else {
-		log.WithFields(log.Fields{
+		logger.WithFields(log.Fields{
 			"error": err.Error(),
 			"stack": errors.ErrorStack(err),
 		}).Error("Unknown error in GRPC call")
 	}
 
-	if client != nil {
-		client.ReportInternal(ctx, err)
+	tags := map[string]string{
+		"rpc_service": build.Name,
+		"rpc_method":  method,
+	}
+	for k, v := range incomingCallerFields(ctx) {
+		tags[k] = v.(string)
+	}
+	reporter.report(ctx, err, tags)
+}
+
+// isExpectedCode returns true for the status codes that are part of the normal
+// operation of the services and should not be notified.
+func isExpectedCode(code codes.Code) bool {
+	switch code {
+	case codes.InvalidArgument, codes.NotFound, codes.AlreadyExists, codes.FailedPrecondition, codes.Aborted, codes.Unimplemented, codes.Canceled:
+		return true
 	}
+	return false
 }
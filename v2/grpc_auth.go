@@ -0,0 +1,261 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// TokenVerifier checks the bearer tokens of the incoming calls.
+type TokenVerifier interface {
+	// Verify returns the principal of the token or an error if it is not valid.
+	Verify(ctx context.Context, token string) (*Principal, error)
+}
+
+// Principal is the verified identity of the caller of a RPC.
+type Principal struct {
+	Subject string
+	Email   string
+	Issuer  string
+
+	// Claims has all the claims of the token.
+	Claims map[string]interface{}
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns the verified caller of the RPC. It is not present
+// in the public methods or if the authentication is not configured.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok
+}
+
+// WithAuthentication requires a valid bearer token in the authorization metadata
+// of all the calls to the GRPC server, except the public methods.
+func WithAuthentication(verifier TokenVerifier) GRPCOption {
+	return func(service *Service) {
+		service.grpcAuth = verifier
+	}
+}
+
+// WithPublicMethods allows calls without a token to the methods. Each one is the
+// full name of the method, like "/foo.Bar/Baz", or all the methods of a service
+// with "/foo.Bar/*". The health checks are always public.
+func WithPublicMethods(methods ...string) GRPCOption {
+	return func(service *Service) {
+		service.grpcPublicMethods = append(service.grpcPublicMethods, methods...)
+	}
+}
+
+type authenticator struct {
+	verifier TokenVerifier
+	public   []string
+}
+
+func newAuthenticator(verifier TokenVerifier, public []string) *authenticator {
+	return &authenticator{
+		verifier: verifier,
+		public:   append([]string{"/grpc.health.v1.Health/*"}, public...),
+	}
+}
+
+func (auth *authenticator) isPublic(method string) bool {
+	for _, public := range auth.public {
+		if public == method {
+			return true
+		}
+		if strings.HasSuffix(public, "/*") && strings.HasPrefix(method, strings.TrimSuffix(public, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate verifies the token of the call and adds the principal to the context.
+func (auth *authenticator) authenticate(ctx context.Context, method string) (context.Context, error) {
+	if auth.isPublic(method) {
+		return ctx, nil
+	}
+
+	token, err := bearerToken(ctx)
+	if err == nil {
+		var principal *Principal
+		principal, err = auth.verifier.Verify(ctx, token)
+		if err == nil {
+			return context.WithValue(ctx, principalKey{}, principal), nil
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"method": method,
+		"error":  err.Error(),
+	}).Warning("Unauthenticated GRPC call")
+
+	return nil, status.Error(codes.Unauthenticated, "invalid or missing credentials")
+}
+
+func bearerToken(ctx context.Context) (string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", errors.New("missing metadata")
+	}
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return "", errors.New("missing authorization metadata")
+	}
+	if !strings.HasPrefix(values[0], "Bearer ") {
+		return "", errors.New("authorization is not a bearer token")
+	}
+	return strings.TrimPrefix(values[0], "Bearer "), nil
+}
+
+func (auth *authenticator) unaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := auth.authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *authenticatedStream) Context() context.Context {
+	return stream.ctx
+}
+
+func (auth *authenticator) streamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := auth.authenticate(stream.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// TokenSource returns the token to authenticate the outgoing calls.
+type TokenSource func(ctx context.Context) (string, error)
+
+// StaticToken always sends the same token.
+func StaticToken(token string) TokenSource {
+	return func(ctx context.Context) (string, error) {
+		return token, nil
+	}
+}
+
+// WithToken sends the token of the source as a bearer token in all the calls of
+// the connection. Outside the local and test environments the connection should
+// use TLS to protect the token.
+func WithToken(source TokenSource) grpc.DialOption {
+	return grpc.WithPerRPCCredentials(&tokenCredentials{source: source})
+}
+
+type tokenCredentials struct {
+	source TokenSource
+}
+
+func (creds *tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token, err := creds.source(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return map[string]string{"authorization": "Bearer " + token}, nil
+}
+
+func (creds *tokenCredentials) RequireTransportSecurity() bool {
+	return !IsLocal()
+}
+
+// GoogleIDTokenSource requests ID tokens for the audience to the metadata server
+// of Google Cloud, signed for the service account of the instance. They can be
+// verified by the remote service with GoogleIDTokenVerifier.
+func GoogleIDTokenSource(audience string) TokenSource {
+	client := &http.Client{Timeout: 10 * time.Second}
+	u := "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/identity?audience=" + url.QueryEscape(audience)
+
+	cache := &cachedToken{
+		timeProvider: time.Now,
+		source: func(ctx context.Context) (string, time.Time, error) {
+			req, err := http.NewRequest(http.MethodGet, u, nil)
+			if err != nil {
+				return "", time.Time{}, errors.Trace(err)
+			}
+			req.Header.Set("Metadata-Flavor", "Google")
+
+			resp, err := client.Do(req.WithContext(ctx))
+			if err != nil {
+				return "", time.Time{}, errors.Trace(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return "", time.Time{}, errors.Errorf("unexpected status requesting the ID token: %s", resp.Status)
+			}
+			content, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				return "", time.Time{}, errors.Trace(err)
+			}
+			token := strings.TrimSpace(string(content))
+
+			// The token comes from the metadata server; we only need its expiration.
+			parsed, err := jwt.ParseSigned(token)
+			if err != nil {
+				return "", time.Time{}, errors.Annotatef(err, "malformed ID token")
+			}
+			var claims jwt.Claims
+			if err := parsed.UnsafeClaimsWithoutVerification(&claims); err != nil {
+				return "", time.Time{}, errors.Annotatef(err, "malformed ID token")
+			}
+			if claims.Expiry == nil {
+				return "", time.Time{}, errors.New("ID token without expiration")
+			}
+
+			return token, claims.Expiry.Time(), nil
+		},
+	}
+	return cache.get
+}
+
+// cachedToken reuses the token of the source until it is about to expire.
+type cachedToken struct {
+	source       func(ctx context.Context) (string, time.Time, error)
+	timeProvider func() time.Time
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (cache *cachedToken) get(ctx context.Context) (string, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.token != "" && cache.timeProvider().Add(5*time.Minute).Before(cache.expires) {
+		return cache.token, nil
+	}
+
+	token, expires, err := cache.source(ctx)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	cache.token, cache.expires = token, expires
+
+	return token, nil
+}
@@ -0,0 +1,281 @@
+package services
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"golang.org/x/sync/singleflight"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	// jwksRefreshInterval is the time we keep the keys before downloading them again.
+	jwksRefreshInterval = time.Hour
+
+	// jwksMinRefreshInterval limits the downloads when a token has an unknown key.
+	jwksMinRefreshInterval = time.Minute
+
+	// jwtLeeway accepts tokens with small differences between the clocks.
+	jwtLeeway = time.Minute
+
+	// jwtMinRSAKeySize rejects the keys too short to be secure.
+	jwtMinRSAKeySize = 2048
+)
+
+// jwtAlgorithms are the signature algorithms accepted in the tokens.
+var jwtAlgorithms = []string{string(jose.RS256), string(jose.ES256)}
+
+// JWTConfig configures the verification of JWT tokens signed with RS256 or ES256.
+type JWTConfig struct {
+	// JWKSURL is the address of the JSON Web Key Set with the public keys.
+	JWKSURL string
+
+	// JWKSFile reads the JSON Web Key Set from a local file instead of JWKSURL.
+	JWKSFile string
+
+	// Issuers accepted in the iss claim. Empty accepts any issuer.
+	Issuers []string
+
+	// Audience that should be present in the aud claim. Empty accepts any audience.
+	Audience string
+}
+
+// JWTVerifier verifies JWT tokens with the public keys of a JSON Web Key Set.
+// The tokens should have an expiration; the keys should be meant for signatures
+// and the RSA ones should have at least 2048 bits.
+func JWTVerifier(config JWTConfig) TokenVerifier {
+	if config.JWKSURL == "" && config.JWKSFile == "" {
+		panic("jwt verifier needs a JWKS URL or file")
+	}
+	return &jwtVerifier{
+		config:       config,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		timeProvider: time.Now,
+	}
+}
+
+// GoogleIDTokenVerifier verifies the ID tokens signed by Google for the audience,
+// like the ones of the service accounts in Google Cloud.
+func GoogleIDTokenVerifier(audience string) TokenVerifier {
+	return JWTVerifier(JWTConfig{
+		JWKSURL:  "https://www.googleapis.com/oauth2/v3/certs",
+		Issuers:  []string{"accounts.google.com", "https://accounts.google.com"},
+		Audience: audience,
+	})
+}
+
+type jwtVerifier struct {
+	config       JWTConfig
+	client       *http.Client
+	timeProvider func() time.Time
+
+	// refreshes shares a single download of the keys between the concurrent calls.
+	refreshes singleflight.Group
+
+	mu          sync.Mutex
+	keys        *jose.JSONWebKeySet
+	lastRefresh time.Time
+	lastAttempt time.Time
+}
+
+type jwtClaims struct {
+	jwt.Claims
+	Email string `json:"email"`
+}
+
+func (verifier *jwtVerifier) Verify(ctx context.Context, token string) (*Principal, error) {
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		return nil, errors.Annotatef(err, "malformed token")
+	}
+	if len(parsed.Headers) != 1 {
+		return nil, errors.New("token should have a single signature")
+	}
+	header := parsed.Headers[0]
+	if !containsString(jwtAlgorithms, header.Algorithm) {
+		return nil, errors.Errorf("unsupported token algorithm: %s", header.Algorithm)
+	}
+	if typ, ok := header.ExtraHeaders[jose.HeaderType]; ok {
+		if s, _ := typ.(string); !strings.EqualFold(s, "JWT") {
+			return nil, errors.Errorf("unexpected token type: %v", typ)
+		}
+	}
+
+	key, err := verifier.key(header.KeyID)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := checkJWK(key, header.Algorithm); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var claims jwtClaims
+	raw := make(map[string]interface{})
+	if err := parsed.Claims(key.Key, &claims, &raw); err != nil {
+		return nil, errors.Annotatef(err, "invalid token")
+	}
+	if err := verifier.validate(claims); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	principal := &Principal{
+		Subject: claims.Subject,
+		Email:   claims.Email,
+		Issuer:  claims.Issuer,
+		Claims:  raw,
+	}
+	return principal, nil
+}
+
+func (verifier *jwtVerifier) validate(claims jwtClaims) error {
+	if claims.Expiry == nil {
+		return errors.New("token without expiration")
+	}
+
+	expected := jwt.Expected{Time: verifier.timeProvider()}
+	if verifier.config.Audience != "" {
+		expected.Audience = jwt.Audience{verifier.config.Audience}
+	}
+	if err := claims.ValidateWithLeeway(expected, jwtLeeway); err != nil {
+		return errors.Annotatef(err, "invalid token")
+	}
+
+	if len(verifier.config.Issuers) > 0 && !containsString(verifier.config.Issuers, claims.Issuer) {
+		return errors.Errorf("unexpected token issuer: %s", claims.Issuer)
+	}
+
+	return nil
+}
+
+// checkJWK rejects the keys that should not be used to verify the token.
+func checkJWK(key *jose.JSONWebKey, algorithm string) error {
+	if !key.Valid() {
+		return errors.Errorf("invalid token key: %s", key.KeyID)
+	}
+	if key.Use != "" && key.Use != "sig" {
+		return errors.Errorf("token key %s is not meant for signatures: %s", key.KeyID, key.Use)
+	}
+	if key.Algorithm != "" && key.Algorithm != algorithm {
+		return errors.Errorf("token algorithm %s does not match the key %s: %s", algorithm, key.KeyID, key.Algorithm)
+	}
+	if rsaKey, ok := key.Key.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < jwtMinRSAKeySize {
+		return errors.Errorf("token key %s is too short: %d bits", key.KeyID, rsaKey.N.BitLen())
+	}
+	return nil
+}
+
+// key returns the public key with the ID, downloading the keys again if they are
+// old or the ID is unknown.
+func (verifier *jwtVerifier) key(id string) (*jose.JSONWebKey, error) {
+	verifier.mu.Lock()
+	key, ok := verifier.lookup(id)
+	refresh := !ok || verifier.timeProvider().Sub(verifier.lastRefresh) > jwksRefreshInterval
+	verifier.mu.Unlock()
+
+	if refresh {
+		_, err, _ := verifier.refreshes.Do("jwks", func() (interface{}, error) {
+			return nil, verifier.refresh()
+		})
+		if err != nil {
+			// Keep using the previous keys while the new ones cannot be loaded.
+			if !ok {
+				return nil, errors.Trace(err)
+			}
+			return key, nil
+		}
+
+		verifier.mu.Lock()
+		key, ok = verifier.lookup(id)
+		verifier.mu.Unlock()
+	}
+	if !ok {
+		return nil, errors.Errorf("unknown token key: %s", id)
+	}
+
+	return key, nil
+}
+
+func (verifier *jwtVerifier) lookup(id string) (*jose.JSONWebKey, bool) {
+	if verifier.keys == nil {
+		return nil, false
+	}
+	keys := verifier.keys.Key(id)
+	if len(keys) == 0 {
+		return nil, false
+	}
+	return &keys[0], true
+}
+
+// refresh downloads the keys without the lock, unless there was another attempt
+// recently. It does not use the context of the call that triggered it because
+// the rest of calls waiting for the keys should not fail if that one is cancelled.
+func (verifier *jwtVerifier) refresh() error {
+	verifier.mu.Lock()
+	now := verifier.timeProvider()
+	if !verifier.lastAttempt.IsZero() && now.Sub(verifier.lastAttempt) < jwksMinRefreshInterval {
+		verifier.mu.Unlock()
+		return nil
+	}
+	verifier.lastAttempt = now
+	verifier.mu.Unlock()
+
+	keys, err := verifier.loadKeys()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	verifier.mu.Lock()
+	defer verifier.mu.Unlock()
+
+	verifier.keys = keys
+	verifier.lastRefresh = verifier.timeProvider()
+
+	return nil
+}
+
+func (verifier *jwtVerifier) loadKeys() (*jose.JSONWebKeySet, error) {
+	var content []byte
+	if verifier.config.JWKSFile != "" {
+		var err error
+		content, err = ioutil.ReadFile(verifier.config.JWKSFile)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	} else {
+		resp, err := verifier.client.Get(verifier.config.JWKSURL)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, errors.Errorf("unexpected status downloading the keys: %s", resp.Status)
+		}
+		content, err = ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	keys := new(jose.JSONWebKeySet)
+	if err := json.Unmarshal(content, keys); err != nil {
+		return nil, errors.Annotatef(err, "malformed key set")
+	}
+	return keys, nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
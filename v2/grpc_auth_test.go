@@ -0,0 +1,265 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type testSigner struct {
+	key      *rsa.PrivateKey
+	verifier *jwtVerifier
+	header   map[string]string
+}
+
+func newTestSigner(t *testing.T) (*testSigner, func()) {
+	return newTestSignerKey(t, 2048, map[string]string{"use": "sig", "alg": "RS256"})
+}
+
+// newTestSignerKey signs with a new RSA key of the size. The JWKS of the verifier
+// has the public key with the extra fields.
+func newTestSignerKey(t *testing.T, bits int, fields map[string]string) (*testSigner, func()) {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "services")
+	require.NoError(t, err)
+
+	jwk := map[string]string{
+		"kid": "test",
+		"kty": "RSA",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+	for k, v := range fields {
+		jwk[k] = v
+	}
+	content, err := json.Marshal(map[string]interface{}{"keys": []map[string]string{jwk}})
+	require.NoError(t, err)
+	filename := filepath.Join(dir, "jwks.json")
+	require.NoError(t, ioutil.WriteFile(filename, content, 0600))
+
+	verifier := JWTVerifier(JWTConfig{
+		JWKSFile: filename,
+		Issuers:  []string{"https://issuer.example.com"},
+		Audience: "users",
+	}).(*jwtVerifier)
+
+	signer := &testSigner{
+		key:      key,
+		verifier: verifier,
+		header:   map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"},
+	}
+	return signer, func() { os.RemoveAll(dir) }
+}
+
+func (signer *testSigner) sign(t *testing.T, claims map[string]interface{}) string {
+	header, err := json.Marshal(signer.header)
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, signer.key, crypto.SHA256, hash[:])
+	require.NoError(t, err)
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":   "https://issuer.example.com",
+		"sub":   "12345",
+		"aud":   []string{"users", "billing"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"email": "foo@example.com",
+	}
+}
+
+func TestJWTVerifier(t *testing.T) {
+	signer, cleanup := newTestSigner(t)
+	defer cleanup()
+
+	principal, err := signer.verifier.Verify(context.Background(), signer.sign(t, validClaims()))
+	require.NoError(t, err)
+	require.Equal(t, principal.Subject, "12345")
+	require.Equal(t, principal.Email, "foo@example.com")
+	require.Equal(t, principal.Issuer, "https://issuer.example.com")
+	require.Equal(t, principal.Claims["sub"], "12345")
+}
+
+func TestJWTVerifierInvalid(t *testing.T) {
+	signer, cleanup := newTestSigner(t)
+	defer cleanup()
+
+	expired := validClaims()
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	audience := validClaims()
+	audience["aud"] = "billing"
+	issuer := validClaims()
+	issuer["iss"] = "https://other.example.com"
+
+	issued := validClaims()
+	issued["iat"] = time.Now().Add(time.Hour).Unix()
+	noExpiration := validClaims()
+	delete(noExpiration, "exp")
+
+	tokens := []string{
+		"",
+		"foo.bar",
+		signer.sign(t, expired),
+		signer.sign(t, audience),
+		signer.sign(t, issuer),
+		signer.sign(t, issued),
+		signer.sign(t, noExpiration),
+		signer.sign(t, validClaims()) + "foo",
+		"eyJhbGciOiJub25lIiwia2lkIjoidGVzdCJ9.eyJzdWIiOiIxMjM0NSJ9.",
+	}
+	for _, token := range tokens {
+		_, err := signer.verifier.Verify(context.Background(), token)
+		require.Error(t, err, token)
+	}
+
+	signer.header["typ"] = "at+jwt"
+	_, err := signer.verifier.Verify(context.Background(), signer.sign(t, validClaims()))
+	require.Error(t, err)
+}
+
+func TestJWTVerifierRejectsKeys(t *testing.T) {
+	tests := []struct {
+		bits   int
+		fields map[string]string
+	}{
+		{bits: 1024},
+		{bits: 2048, fields: map[string]string{"use": "enc"}},
+		{bits: 2048, fields: map[string]string{"alg": "RS512"}},
+	}
+	for _, test := range tests {
+		signer, cleanup := newTestSignerKey(t, test.bits, test.fields)
+		_, err := signer.verifier.Verify(context.Background(), signer.sign(t, validClaims()))
+		cleanup()
+		require.Error(t, err, "%v", test)
+	}
+}
+
+func TestJWTVerifierSharesRefresh(t *testing.T) {
+	signer, cleanup := newTestSigner(t)
+	defer cleanup()
+
+	content, err := ioutil.ReadFile(signer.verifier.config.JWKSFile)
+	require.NoError(t, err)
+	var downloads int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&downloads, 1)
+		<-release
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	verifier := JWTVerifier(JWTConfig{JWKSURL: server.URL}).(*jwtVerifier)
+	token := signer.sign(t, validClaims())
+
+	// Cancelling the call that started the download does not fail the others.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := verifier.Verify(ctx, token)
+			errs <- err
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.EqualValues(t, atomic.LoadInt32(&downloads), 1)
+}
+
+func TestAuthenticator(t *testing.T) {
+	signer, cleanup := newTestSigner(t)
+	defer cleanup()
+
+	auth := newAuthenticator(signer.verifier, []string{"/foo.Public/*", "/foo.Bar/Login"})
+	interceptor := auth.unaryInterceptor()
+
+	var principal *Principal
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		principal, _ = PrincipalFromContext(ctx)
+		return nil, nil
+	}
+	call := func(ctx context.Context, method string) error {
+		principal = nil
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+
+	require.NoError(t, call(context.Background(), "/foo.Public/Baz"))
+	require.NoError(t, call(context.Background(), "/foo.Bar/Login"))
+	require.NoError(t, call(context.Background(), "/grpc.health.v1.Health/Check"))
+	require.Nil(t, principal)
+
+	err := call(context.Background(), "/foo.Bar/Baz")
+	require.Equal(t, status.Code(err), codes.Unauthenticated)
+
+	creds := &tokenCredentials{source: StaticToken(signer.sign(t, validClaims()))}
+	md, err := creds.GetRequestMetadata(context.Background())
+	require.NoError(t, err)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.New(md))
+	require.NoError(t, call(ctx, "/foo.Bar/Baz"))
+	require.Equal(t, principal.Subject, "12345")
+}
+
+func TestCachedToken(t *testing.T) {
+	now := time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC)
+	var calls int
+	cache := &cachedToken{
+		timeProvider: func() time.Time { return now },
+		source: func(ctx context.Context) (string, time.Time, error) {
+			calls++
+			return "foo", now.Add(time.Hour), nil
+		},
+	}
+
+	for i := 0; i < 3; i++ {
+		token, err := cache.get(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, token, "foo")
+	}
+	require.Equal(t, calls, 1)
+
+	now = now.Add(56 * time.Minute)
+	_, err := cache.get(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, calls, 2)
+}
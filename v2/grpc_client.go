@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// defaultCallTimeout is the deadline of the unary calls that do not have one.
+	defaultCallTimeout = 30 * time.Second
+
+	retryAttempts  = 4
+	retryBaseDelay = 100 * time.Millisecond
+	retryMaxDelay  = 2 * time.Second
+
+	callerAppHeader    = "x-services-caller-app"
+	callerMethodHeader = "x-services-caller-method"
+)
+
+// Idempotent marks a call as safe to repeat. Idempotent calls that fail with
+// Unavailable are retried with exponential backoff.
+func Idempotent() grpc.CallOption {
+	return idempotentOption{}
+}
+
+type idempotentOption struct {
+	grpc.EmptyCallOption
+}
+
+func isIdempotent(opts []grpc.CallOption) bool {
+	for _, opt := range opts {
+		if _, ok := opt.(idempotentOption); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// retryBackoff returns the delay before the next attempt using exponential
+// backoff with jitter.
+func retryBackoff(attempt int) time.Duration {
+	delay := retryBaseDelay << uint(attempt)
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+}
+
+type rpcCallerKey struct{}
+
+type rpcCaller struct {
+	app, method string
+}
+
+// withRPCCaller stores the RPC we are serving to send it to the remote services
+// we call while serving it.
+func withRPCCaller(ctx context.Context, app, method string) context.Context {
+	return context.WithValue(ctx, rpcCallerKey{}, rpcCaller{app, method})
+}
+
+func outgoingCaller(ctx context.Context) context.Context {
+	caller, ok := ctx.Value(rpcCallerKey{}).(rpcCaller)
+	if !ok {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, callerAppHeader, caller.app, callerMethodHeader, caller.method)
+}
+
+// incomingCallerFields returns the log fields of the remote service that called us.
+func incomingCallerFields(ctx context.Context) log.Fields {
+	fields := log.Fields{}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return fields
+	}
+	if values := md.Get(callerAppHeader); len(values) > 0 {
+		fields["caller-app"] = values[0]
+	}
+	if values := md.Get(callerMethodHeader); len(values) > 0 {
+		fields["caller-method"] = values[0]
+	}
+	return fields
+}
+
+func unaryClientInterceptor(timeout time.Duration, backoff func(attempt int) time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		ctx = outgoingCaller(ctx)
+
+		retry := isIdempotent(opts)
+		var err error
+	attempts:
+		for attempt := 0; attempt < retryAttempts; attempt++ {
+			err = invoker(ctx, method, req, reply, cc, opts...)
+			if err == nil || !retry || status.Code(err) != codes.Unavailable || attempt == retryAttempts-1 {
+				break
+			}
+
+			select {
+			case <-time.After(backoff(attempt)):
+			case <-ctx.Done():
+				break attempts
+			}
+		}
+		if err != nil {
+			logClientError(ctx, method, err)
+		}
+
+		return err
+	}
+}
+
+// streamClientInterceptor does not apply a default deadline nor retries because
+// streams are usually long lived and cannot be repeated.
+func streamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		stream, err := streamer(outgoingCaller(ctx), desc, cc, method, opts...)
+		if err != nil {
+			logClientError(ctx, method, err)
+			return nil, err
+		}
+
+		return &loggedClientStream{ClientStream: stream, ctx: ctx, method: method}, nil
+	}
+}
+
+type loggedClientStream struct {
+	grpc.ClientStream
+	ctx    context.Context
+	method string
+}
+
+func (stream *loggedClientStream) RecvMsg(m interface{}) error {
+	err := stream.ClientStream.RecvMsg(m)
+	if err != nil && err != io.EOF {
+		logClientError(stream.ctx, stream.method, err)
+	}
+	return err
+}
+
+// logClientError logs the failed outgoing calls. They are not reported to Sentry,
+// the server that failed reports them already. The status codes that are not
+// notified by the servers are logged as information.
+func logClientError(ctx context.Context, method string, err error) {
+	build := newBuildInfo("")
+	fields := log.Fields{
+		"version": build.Version,
+		"commit":  build.Commit,
+		"method":  method,
+	}
+	if caller, ok := ctx.Value(rpcCallerKey{}).(rpcCaller); ok {
+		fields["caller-app"] = caller.app
+		fields["caller-method"] = caller.method
+	}
+	logger := log.WithFields(fields)
+
+	if grpcerr, ok := status.FromError(err); ok {
+		logger = logger.WithFields(log.Fields{
+			"code":    grpcerr.Code().String(),
+			"message": grpcerr.Message(),
+		})
+		if isExpectedCode(grpcerr.Code()) {
+			logger.Info("Outgoing GRPC call failed")
+			return
+		}
+		logger.Error("Outgoing GRPC call failed")
+	} else {
+		logger.WithFields(log.Fields{
+			"error": err.Error(),
+			"stack": errors.ErrorStack(err),
+		}).Error("Unknown error in outgoing GRPC call")
+	}
+}
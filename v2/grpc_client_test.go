@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func noBackoff(attempt int) time.Duration { return 0 }
+
+func TestUnaryClientRetriesIdempotent(t *testing.T) {
+	interceptor := unaryClientInterceptor(time.Second, noBackoff)
+
+	var calls int
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		if calls < 3 {
+			return status.Error(codes.Unavailable, "unavailable")
+		}
+		return nil
+	}
+
+	err := interceptor(context.Background(), "/foo.Bar/Baz", nil, nil, nil, invoker, Idempotent())
+	require.NoError(t, err)
+	require.Equal(t, calls, 3)
+}
+
+func TestUnaryClientRetriesLimit(t *testing.T) {
+	interceptor := unaryClientInterceptor(time.Second, noBackoff)
+
+	var calls int
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		return status.Error(codes.Unavailable, "unavailable")
+	}
+
+	err := interceptor(context.Background(), "/foo.Bar/Baz", nil, nil, nil, invoker, Idempotent())
+	require.Equal(t, status.Code(err), codes.Unavailable)
+	require.Equal(t, calls, retryAttempts)
+}
+
+func TestUnaryClientNoRetries(t *testing.T) {
+	interceptor := unaryClientInterceptor(time.Second, noBackoff)
+
+	var calls int
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		return status.Error(codes.Unavailable, "unavailable")
+	}
+
+	err := interceptor(context.Background(), "/foo.Bar/Baz", nil, nil, nil, invoker)
+	require.Equal(t, status.Code(err), codes.Unavailable)
+	require.Equal(t, calls, 1)
+
+	calls = 0
+	invoker = func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		return status.Error(codes.Internal, "internal")
+	}
+	err = interceptor(context.Background(), "/foo.Bar/Baz", nil, nil, nil, invoker, Idempotent())
+	require.Equal(t, status.Code(err), codes.Internal)
+	require.Equal(t, calls, 1)
+}
+
+func TestUnaryClientDefaultDeadline(t *testing.T) {
+	interceptor := unaryClientInterceptor(time.Minute, noBackoff)
+
+	var deadline time.Time
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		deadline, _ = ctx.Deadline()
+		return nil
+	}
+
+	require.NoError(t, interceptor(context.Background(), "/foo.Bar/Baz", nil, nil, nil, invoker))
+	require.WithinDuration(t, deadline, time.Now().Add(time.Minute), time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, interceptor(ctx, "/foo.Bar/Baz", nil, nil, nil, invoker))
+	require.WithinDuration(t, deadline, time.Now().Add(time.Second), time.Second)
+}
+
+func TestUnaryClientPropagatesCaller(t *testing.T) {
+	interceptor := unaryClientInterceptor(time.Second, noBackoff)
+
+	var md metadata.MD
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+
+	ctx := withRPCCaller(context.Background(), "foo", "/foo.Foo/Bar")
+	require.NoError(t, interceptor(ctx, "/bar.Bar/Baz", nil, nil, nil, invoker))
+
+	require.Equal(t, md.Get("x-services-caller-app"), []string{"foo"})
+	require.Equal(t, md.Get("x-services-caller-method"), []string{"/foo.Foo/Bar"})
+
+	fields := incomingCallerFields(metadata.NewIncomingContext(context.Background(), md))
+	require.Equal(t, fields["caller-app"], "foo")
+	require.Equal(t, fields["caller-method"], "/foo.Foo/Bar")
+}
+
+func TestLogClientErrorLevels(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	ctx := withRPCCaller(context.Background(), "foo", "/foo.Foo/Bar")
+	logClientError(ctx, "/bar.Bar/Baz", status.Error(codes.NotFound, "not found"))
+	require.Equal(t, hook.LastEntry().Level, log.InfoLevel)
+	require.Equal(t, hook.LastEntry().Data["caller-app"], "foo")
+
+	logClientError(ctx, "/bar.Bar/Baz", status.Error(codes.Internal, "internal"))
+	require.Equal(t, hook.LastEntry().Level, log.ErrorLevel)
+}
+
+func TestDialChainsInterceptors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(listener)
+	defer server.Stop()
+
+	// The built-in interceptors run before and set the default deadline.
+	var deadline bool
+	interceptor := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		_, deadline = ctx.Deadline()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	conn, err := Dial(Endpoint("passthrough:///"+listener.Addr().String()), grpc.WithInsecure(), grpc.WithChainUnaryInterceptor(interceptor))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = healthpb.NewHealthClient(conn).Check(context.Background(), new(healthpb.HealthCheckRequest))
+	require.NoError(t, err)
+	require.True(t, deadline)
+}
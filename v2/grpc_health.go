@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// grpcHealthWatchInterval is the time between the readiness checks sent to the
+// watchers of the overall server status.
+const grpcHealthWatchInterval = 2 * time.Second
+
+// grpcHealthServer implements the standard GRPC health checking protocol using
+// the same readiness checks of the /readyz endpoint for the overall server status.
+type grpcHealthServer struct {
+	*health.Server
+	checker       *healthChecker
+	services      []string
+	watchInterval time.Duration
+
+	// draining is closed when the server starts draining to notify the watchers
+	// of the overall status without waiting for the next check.
+	draining     chan struct{}
+	drainingOnce sync.Once
+}
+
+func newGRPCHealthServer(checker *healthChecker) *grpcHealthServer {
+	return &grpcHealthServer{
+		Server:        health.NewServer(),
+		checker:       checker,
+		watchInterval: grpcHealthWatchInterval,
+		draining:      make(chan struct{}),
+	}
+}
+
+func (server *grpcHealthServer) Check(ctx context.Context, in *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if in.Service == "" {
+		return &healthpb.HealthCheckResponse{Status: server.readiness()}, nil
+	}
+
+	return server.Server.Check(ctx, in)
+}
+
+// Watch sends the readiness of the server when the overall status is requested,
+// checking it periodically and sending only the changes. The status of the
+// GRPC services comes from the embedded server.
+func (server *grpcHealthServer) Watch(in *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	if in.Service != "" {
+		return server.Server.Watch(in, stream)
+	}
+
+	ticker := time.NewTicker(server.watchInterval)
+	defer ticker.Stop()
+
+	draining := server.draining
+	last := healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+	for {
+		if current := server.readiness(); current != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: current}); err != nil {
+				return status.Error(codes.Canceled, "Stream has ended.")
+			}
+			last = current
+		}
+
+		select {
+		case <-ticker.C:
+		case <-draining:
+			// Stop listening to the closed channel, the status will not change anymore.
+			draining = nil
+		case <-stream.Context().Done():
+			return status.Error(codes.Canceled, "Stream has ended.")
+		}
+	}
+}
+
+func (server *grpcHealthServer) readiness() healthpb.HealthCheckResponse_ServingStatus {
+	if server.checker.ready().Status != "ok" {
+		return healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	return healthpb.HealthCheckResponse_SERVING
+}
+
+// markServing sets the status of every registered GRPC service to serving.
+func (server *grpcHealthServer) markServing(services []string) {
+	server.services = services
+	for _, name := range services {
+		server.SetServingStatus(name, healthpb.HealthCheckResponse_SERVING)
+	}
+}
+
+// startDraining marks the server and all its services as not serving to notify
+// the watchers.
+func (server *grpcHealthServer) startDraining() {
+	server.drainingOnce.Do(func() {
+		close(server.draining)
+	})
+	for _, name := range server.services {
+		server.SetServingStatus(name, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+}
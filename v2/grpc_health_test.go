@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+type testWatchStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	replies chan healthpb.HealthCheckResponse_ServingStatus
+}
+
+func (stream *testWatchStream) Context() context.Context {
+	return stream.ctx
+}
+
+func (stream *testWatchStream) Send(reply *healthpb.HealthCheckResponse) error {
+	stream.replies <- reply.Status
+	return nil
+}
+
+func TestGRPCHealthCheck(t *testing.T) {
+	checker := newHealthChecker()
+	checker.cache = 0
+	var failed int32
+	checker.addReadiness("database", func(ctx context.Context) error {
+		if atomic.LoadInt32(&failed) == 1 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	server := newGRPCHealthServer(checker)
+
+	reply, err := server.Check(context.Background(), new(healthpb.HealthCheckRequest))
+	require.NoError(t, err)
+	require.Equal(t, reply.Status, healthpb.HealthCheckResponse_SERVING)
+
+	atomic.StoreInt32(&failed, 1)
+	reply, err = server.Check(context.Background(), new(healthpb.HealthCheckRequest))
+	require.NoError(t, err)
+	require.Equal(t, reply.Status, healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+func TestGRPCHealthWatchReadiness(t *testing.T) {
+	checker := newHealthChecker()
+	checker.cache = 0
+	var failed int32
+	checker.addReadiness("database", func(ctx context.Context) error {
+		if atomic.LoadInt32(&failed) == 1 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	server := newGRPCHealthServer(checker)
+	server.watchInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &testWatchStream{
+		ctx:     ctx,
+		replies: make(chan healthpb.HealthCheckResponse_ServingStatus, 10),
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Watch(new(healthpb.HealthCheckRequest), stream)
+	}()
+	require.Equal(t, <-stream.replies, healthpb.HealthCheckResponse_SERVING)
+
+	atomic.StoreInt32(&failed, 1)
+	require.Equal(t, <-stream.replies, healthpb.HealthCheckResponse_NOT_SERVING)
+
+	atomic.StoreInt32(&failed, 0)
+	require.Equal(t, <-stream.replies, healthpb.HealthCheckResponse_SERVING)
+
+	cancel()
+	require.Error(t, <-done)
+}
+
+func TestGRPCHealthWatchDraining(t *testing.T) {
+	checker := newHealthChecker()
+	server := newGRPCHealthServer(checker)
+	server.watchInterval = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := &testWatchStream{
+		ctx:     ctx,
+		replies: make(chan healthpb.HealthCheckResponse_ServingStatus, 10),
+	}
+	go server.Watch(new(healthpb.HealthCheckRequest), stream)
+	require.Equal(t, <-stream.replies, healthpb.HealthCheckResponse_SERVING)
+
+	// The watchers are notified without waiting for the next check.
+	checker.startDraining()
+	server.startDraining()
+	require.Equal(t, <-stream.replies, healthpb.HealthCheckResponse_NOT_SERVING)
+}
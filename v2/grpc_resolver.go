@@ -0,0 +1,118 @@
+package services
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+)
+
+// defaultServiceConfig balances the calls between all the addresses of the
+// target instead of pinning the connection to the first one.
+const defaultServiceConfig = `{"loadBalancingConfig": [{"round_robin": {}}]}`
+
+// WithServiceConfig replaces the default service config of the connection. It
+// can configure the timeouts of each method. Include the round_robin load
+// balancing config to keep the calls balanced:
+//
+//	{"loadBalancingConfig": [{"round_robin": {}}], "methodConfig": [...]}
+//
+// The retryPolicy of the methods is ignored by the GRPC version we use unless
+// the environment variable GRPC_GO_RETRY=on is set when the application starts.
+// Use Idempotent() in the calls to retry them instead.
+func WithServiceConfig(config string) grpc.DialOption {
+	return grpc.WithDefaultServiceConfig(config)
+}
+
+// WithKeepalive sends pings to the server every interval when the connection is
+// idle and closes it if there is no answer after the timeout. The interval should
+// be higher than the minimum allowed by the server or it will close the connection.
+func WithKeepalive(interval, timeout time.Duration) grpc.DialOption {
+	return grpc.WithKeepaliveParams(keepalive.ClientParameters{
+		Time:                interval,
+		Timeout:             timeout,
+		PermitWithoutStream: true,
+	})
+}
+
+// dialTarget prepares the target of the connection. Targets without a scheme use
+// the DNS resolver to balance between all the addresses of headless services.
+//
+// For local development the addresses can be written in the target directly:
+//
+//	static:///localhost:9001,localhost:9002
+//
+// or read from a file with one address per line:
+//
+//	file:///path/to/addresses.txt
+func dialTarget(target Endpoint) (string, []grpc.DialOption, error) {
+	switch {
+	case strings.HasPrefix(string(target), "static:///"):
+		var addrs []string
+		for _, addr := range strings.Split(strings.TrimPrefix(string(target), "static:///"), ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				addrs = append(addrs, addr)
+			}
+		}
+		if len(addrs) == 0 {
+			return "", nil, errors.Errorf("no addresses in target: %s", target)
+		}
+		return string(target), staticResolver("static", addrs), nil
+
+	case strings.HasPrefix(string(target), "file:///"):
+		addrs, err := readAddresses(strings.TrimPrefix(string(target), "file://"))
+		if err != nil {
+			return "", nil, errors.Trace(err)
+		}
+		return string(target), staticResolver("file", addrs), nil
+
+	case strings.Contains(string(target), "://"):
+		return string(target), nil, nil
+	}
+
+	return "dns:///" + string(target), nil, nil
+}
+
+// staticResolver always returns the same list of addresses for the scheme.
+func staticResolver(scheme string, addrs []string) []grpc.DialOption {
+	var state resolver.State
+	for _, addr := range addrs {
+		state.Addresses = append(state.Addresses, resolver.Address{Addr: addr})
+	}
+
+	r := manual.NewBuilderWithScheme(scheme)
+	r.InitialState(state)
+	return []grpc.DialOption{grpc.WithResolvers(r)}
+}
+
+func readAddresses(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot read addresses file")
+	}
+	defer f.Close()
+
+	var addrs []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addrs = append(addrs, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(addrs) == 0 {
+		return nil, errors.Errorf("no addresses in file: %s", filename)
+	}
+
+	return addrs, nil
+}
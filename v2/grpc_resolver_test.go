@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestDialTarget(t *testing.T) {
+	address, opts, err := dialTarget("users:9000")
+	require.NoError(t, err)
+	require.Equal(t, address, "dns:///users:9000")
+	require.Empty(t, opts)
+
+	address, opts, err = dialTarget("passthrough:///bufconn")
+	require.NoError(t, err)
+	require.Equal(t, address, "passthrough:///bufconn")
+	require.Empty(t, opts)
+
+	address, opts, err = dialTarget("static:///localhost:9001, localhost:9002")
+	require.NoError(t, err)
+	require.Equal(t, address, "static:///localhost:9001, localhost:9002")
+	require.Len(t, opts, 1)
+
+	_, _, err = dialTarget("static:///")
+	require.Error(t, err)
+}
+
+func TestReadAddresses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "services")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "addresses.txt")
+	content := "# Local replicas\nlocalhost:9001\n\nlocalhost:9002\n"
+	require.NoError(t, ioutil.WriteFile(filename, []byte(content), 0600))
+
+	addrs, err := readAddresses(filename)
+	require.NoError(t, err)
+	require.Equal(t, addrs, []string{"localhost:9001", "localhost:9002"})
+
+	_, _, err = dialTarget(Endpoint("file://" + filepath.Join(dir, "missing.txt")))
+	require.Error(t, err)
+}
+
+func TestDialStatic(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := Dial(Endpoint("static:///"+listener.Addr().String()), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), new(healthpb.HealthCheckRequest))
+	require.NoError(t, err)
+	require.Equal(t, resp.Status, healthpb.HealthCheckResponse_SERVING)
+}
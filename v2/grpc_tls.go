@@ -0,0 +1,179 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// certReloadInterval is the minimum time between checks of the certificate files
+// to detect a rotation.
+const certReloadInterval = 10 * time.Second
+
+// WithTLS serves the GRPC server with the certificate and key files. The files
+// are read again when they change, for example when a Kubernetes secret rotates.
+func WithTLS(certFile, keyFile string) GRPCOption {
+	return func(service *Service) {
+		service.grpcCertFile = certFile
+		service.grpcKeyFile = keyFile
+	}
+}
+
+// WithClientCA requires the clients of the GRPC server to present a certificate
+// signed by one of the CAs of the file. It needs WithTLS too.
+func WithClientCA(caFile string) GRPCOption {
+	return func(service *Service) {
+		service.grpcClientCAFile = caFile
+	}
+}
+
+// WithClientCertificate authenticates the connection with the certificate and key
+// files and verifies the server with the CAs of the file. The certificate is read
+// again when it changes; changes of the CAs need a new connection.
+func WithClientCertificate(certFile, keyFile, caFile string) (grpc.DialOption, error) {
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := reloader.load(); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	pool, err := loadCertPool(caFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	config := &tls.Config{
+		MinVersion:           tls.VersionTLS12,
+		RootCAs:              pool,
+		GetClientCertificate: reloader.clientCertificate,
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(config)), nil
+}
+
+// certReloader reads the certificate files again when they change.
+type certReloader struct {
+	certFile, keyFile, caFile string
+
+	mu        sync.Mutex
+	lastCheck time.Time
+	modTimes  []time.Time
+	cert      *tls.Certificate
+	pool      *x509.CertPool
+}
+
+// load reads the files if they changed since the last time.
+func (reloader *certReloader) load() error {
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+
+	return errors.Trace(reloader.loadLocked())
+}
+
+func (reloader *certReloader) loadLocked() error {
+	files := []string{reloader.certFile, reloader.keyFile}
+	if reloader.caFile != "" {
+		files = append(files, reloader.caFile)
+	}
+
+	var modTimes []time.Time
+	changed := reloader.cert == nil
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		modTimes = append(modTimes, info.ModTime())
+		if i >= len(reloader.modTimes) || !info.ModTime().Equal(reloader.modTimes[i]) {
+			changed = true
+		}
+	}
+	reloader.lastCheck = time.Now()
+	if !changed {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return errors.Annotatef(err, "cannot load certificate")
+	}
+	var pool *x509.CertPool
+	if reloader.caFile != "" {
+		pool, err = loadCertPool(reloader.caFile)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if reloader.cert != nil {
+		log.WithField("cert", reloader.certFile).Info("Certificates reloaded")
+	}
+	reloader.cert = &cert
+	reloader.pool = pool
+	reloader.modTimes = modTimes
+
+	return nil
+}
+
+// current returns the loaded certificates, checking the files if enough time has
+// passed. If the new files are invalid it keeps the previous ones.
+func (reloader *certReloader) current() (*tls.Certificate, *x509.CertPool, error) {
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+
+	if reloader.cert == nil || time.Since(reloader.lastCheck) > certReloadInterval {
+		if err := reloader.loadLocked(); err != nil {
+			if reloader.cert == nil {
+				return nil, nil, errors.Trace(err)
+			}
+			log.WithFields(log.Fields{
+				"cert":  reloader.certFile,
+				"error": err.Error(),
+			}).Error("Cannot reload certificates, using the previous ones")
+		}
+	}
+
+	return reloader.cert, reloader.pool, nil
+}
+
+func (reloader *certReloader) serverConfig(*tls.ClientHelloInfo) (*tls.Config, error) {
+	cert, pool, err := reloader.current()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*cert},
+		NextProtos:   []string{"h2"},
+	}
+	if pool != nil {
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+func (reloader *certReloader) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	cert, _, err := reloader.current()
+	return cert, errors.Trace(err)
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	content, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, errors.Errorf("no certificates in the CA file: %s", caFile)
+	}
+	return pool, nil
+}
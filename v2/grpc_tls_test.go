@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, dir string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", der)
+
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) issue(t *testing.T, dir, name string, serial int64) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	writePEM(t, filepath.Join(dir, name+".pem"), "CERTIFICATE", der)
+	writePEM(t, filepath.Join(dir, name+"-key.pem"), "EC PRIVATE KEY", keyDER)
+}
+
+func writePEM(t *testing.T, filename, blockType string, der []byte) {
+	content := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	require.NoError(t, ioutil.WriteFile(filename, content, 0600))
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "services")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	ca.issue(t, dir, "server", 2)
+	ca.issue(t, dir, "client", 3)
+
+	reloader := &certReloader{
+		certFile: filepath.Join(dir, "server.pem"),
+		keyFile:  filepath.Join(dir, "server-key.pem"),
+		caFile:   filepath.Join(dir, "ca.pem"),
+	}
+	require.NoError(t, reloader.load())
+	creds := credentials.NewTLS(&tls.Config{GetConfigForClient: reloader.serverConfig})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer(grpc.Creds(creds))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(listener)
+	defer server.Stop()
+
+	clientCert, err := WithClientCertificate(filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem"), filepath.Join(dir, "ca.pem"))
+	require.NoError(t, err)
+	conn, err := Dial(Endpoint("passthrough:///"+listener.Addr().String()), clientCert)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), new(healthpb.HealthCheckRequest))
+	require.NoError(t, err)
+	require.Equal(t, resp.Status, healthpb.HealthCheckResponse_SERVING)
+
+	// Clients without certificate are rejected.
+	pool, err := loadCertPool(filepath.Join(dir, "ca.pem"))
+	require.NoError(t, err)
+	anonymous := grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: pool}))
+	other, err := Dial(Endpoint("passthrough:///"+listener.Addr().String()), anonymous)
+	require.NoError(t, err)
+	defer other.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = healthpb.NewHealthClient(other).Check(ctx, new(healthpb.HealthCheckRequest))
+	require.Error(t, err)
+}
+
+func TestCertReloaderRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "services")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	ca.issue(t, dir, "server", 2)
+
+	reloader := &certReloader{
+		certFile: filepath.Join(dir, "server.pem"),
+		keyFile:  filepath.Join(dir, "server-key.pem"),
+	}
+	cert, pool, err := reloader.current()
+	require.NoError(t, err)
+	require.Nil(t, pool)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	require.EqualValues(t, leaf.SerialNumber.Int64(), 2)
+
+	ca.issue(t, dir, "server", 4)
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(reloader.certFile, future, future))
+
+	// Before the interval passes it keeps the previous certificate.
+	cert, _, err = reloader.current()
+	require.NoError(t, err)
+	leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	require.EqualValues(t, leaf.SerialNumber.Int64(), 2)
+
+	reloader.lastCheck = time.Time{}
+	cert, _, err = reloader.current()
+	require.NoError(t, err)
+	leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	require.EqualValues(t, leaf.SerialNumber.Int64(), 4)
+
+	// Broken files keep the previous certificate.
+	require.NoError(t, ioutil.WriteFile(reloader.keyFile, []byte("foo"), 0600))
+	reloader.lastCheck = time.Time{}
+	cert, _, err = reloader.current()
+	require.NoError(t, err)
+	leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	require.EqualValues(t, leaf.SerialNumber.Int64(), 4)
+}
@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// HealthCheck verifies that a dependency of the service is working correctly. It
+// should return an error if the service cannot serve requests right now.
+type HealthCheck func(ctx context.Context) error
+
+type registeredCheck struct {
+	name  string
+	check HealthCheck
+
+	mu      sync.Mutex
+	lastRun time.Time
+	lastErr error
+}
+
+func (rc *registeredCheck) run(timeout, cache time.Duration) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if !rc.lastRun.IsZero() && time.Since(rc.lastRun) < cache {
+		return rc.lastErr
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- rc.check(ctx)
+	}()
+
+	select {
+	case err := <-result:
+		rc.lastErr = err
+	case <-ctx.Done():
+		rc.lastErr = ctx.Err()
+	}
+	rc.lastRun = time.Now()
+
+	if rc.lastErr != nil {
+		log.WithFields(log.Fields{
+			"check": rc.name,
+			"error": rc.lastErr.Error(),
+		}).Error("Health check failed")
+	}
+
+	return rc.lastErr
+}
+
+type healthChecker struct {
+	timeout, cache time.Duration
+
+	mu        sync.RWMutex
+	readiness []*registeredCheck
+	liveness  []*registeredCheck
+	draining  bool
+}
+
+func newHealthChecker() *healthChecker {
+	return &healthChecker{
+		timeout: 5 * time.Second,
+		cache:   2 * time.Second,
+	}
+}
+
+func (checker *healthChecker) addReadiness(name string, check HealthCheck) {
+	checker.mu.Lock()
+	defer checker.mu.Unlock()
+
+	checker.readiness = append(checker.readiness, &registeredCheck{name: name, check: check})
+}
+
+func (checker *healthChecker) addLiveness(name string, check HealthCheck) {
+	checker.mu.Lock()
+	defer checker.mu.Unlock()
+
+	checker.liveness = append(checker.liveness, &registeredCheck{name: name, check: check})
+}
+
+// startDraining makes the readiness checks fail from now on.
+func (checker *healthChecker) startDraining() {
+	checker.mu.Lock()
+	defer checker.mu.Unlock()
+
+	checker.draining = true
+}
+
+func (checker *healthChecker) isDraining() bool {
+	checker.mu.RLock()
+	defer checker.mu.RUnlock()
+
+	return checker.draining
+}
+
+type healthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkReport `json:"checks,omitempty"`
+}
+
+type checkReport struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (checker *healthChecker) runChecks(checks []*registeredCheck) healthReport {
+	report := healthReport{
+		Status: "ok",
+		Checks: make(map[string]checkReport),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, rc := range checks {
+		wg.Add(1)
+		go func(rc *registeredCheck) {
+			defer wg.Done()
+
+			result := checkReport{Status: "ok"}
+			if err := rc.run(checker.timeout, checker.cache); err != nil {
+				result.Status = "failed"
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[rc.name] = result
+			if result.Status != "ok" {
+				report.Status = "failed"
+			}
+		}(rc)
+	}
+	wg.Wait()
+
+	return report
+}
+
+func (checker *healthChecker) ready() healthReport {
+	if checker.isDraining() {
+		return healthReport{Status: "draining"}
+	}
+
+	checker.mu.RLock()
+	checks := checker.readiness
+	checker.mu.RUnlock()
+
+	return checker.runChecks(checks)
+}
+
+func (checker *healthChecker) live() healthReport {
+	checker.mu.RLock()
+	checks := checker.liveness
+	checker.mu.RUnlock()
+
+	return checker.runChecks(checks)
+}
+
+func (checker *healthChecker) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, checker.live())
+}
+
+func (checker *healthChecker) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, checker.ready())
+}
+
+func writeHealthReport(w http.ResponseWriter, report healthReport) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.WithField("error", err.Error()).Error("Cannot encode health report")
+	}
+}
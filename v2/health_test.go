@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadinessWithoutChecks(t *testing.T) {
+	checker := newHealthChecker()
+
+	w := httptest.NewRecorder()
+	checker.readinessHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+
+	require.Equal(t, w.Code, http.StatusOK)
+	require.JSONEq(t, w.Body.String(), `{"status": "ok"}`)
+}
+
+func TestReadinessFailedCheck(t *testing.T) {
+	checker := newHealthChecker()
+	checker.addReadiness("database", func(ctx context.Context) error { return nil })
+	checker.addReadiness("pubsub", func(ctx context.Context) error { return errors.New("connection refused") })
+
+	w := httptest.NewRecorder()
+	checker.readinessHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+
+	require.Equal(t, w.Code, http.StatusServiceUnavailable)
+	require.JSONEq(t, w.Body.String(), `{
+		"status": "failed",
+		"checks": {
+			"database": {"status": "ok"},
+			"pubsub": {"status": "failed", "error": "connection refused"}
+		}
+	}`)
+}
+
+func TestReadinessDraining(t *testing.T) {
+	checker := newHealthChecker()
+	checker.addReadiness("database", func(ctx context.Context) error { return nil })
+	checker.startDraining()
+
+	w := httptest.NewRecorder()
+	checker.readinessHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+
+	require.Equal(t, w.Code, http.StatusServiceUnavailable)
+	require.JSONEq(t, w.Body.String(), `{"status": "draining"}`)
+}
+
+func TestLivenessIgnoresDraining(t *testing.T) {
+	checker := newHealthChecker()
+	checker.startDraining()
+
+	w := httptest.NewRecorder()
+	checker.livenessHandler(w, httptest.NewRequest("GET", "/healthz", nil))
+
+	require.Equal(t, w.Code, http.StatusOK)
+}
+
+func TestHealthCheckTimeout(t *testing.T) {
+	checker := newHealthChecker()
+	checker.timeout = 10 * time.Millisecond
+	checker.addReadiness("slow", func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+
+	report := checker.ready()
+
+	require.Equal(t, report.Status, "failed")
+	require.Equal(t, report.Checks["slow"].Error, context.DeadlineExceeded.Error())
+}
+
+func TestHealthCheckCache(t *testing.T) {
+	checker := newHealthChecker()
+	var calls int
+	checker.addReadiness("counter", func(ctx context.Context) error {
+		calls++
+		return nil
+	})
+
+	checker.ready()
+	checker.ready()
+
+	require.Equal(t, calls, 1)
+}
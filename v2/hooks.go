@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Hook is a function that runs when the service starts or stops. The context
+// will be cancelled when the global deadline of all the hooks is reached.
+type Hook func(ctx context.Context) error
+
+func hookName(hook Hook) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(hook).Pointer())
+	if fn == nil {
+		return "unknown"
+	}
+	return fn.Name()
+}
+
+// runHooks executes the hooks sequentially in the order of the list. If stopOnError
+// is true the first failing hook will stop the execution of the rest; otherwise
+// all of them are executed and the first error is returned at the end.
+func runHooks(ctx context.Context, stage string, hooks []Hook, stopOnError bool) error {
+	var first error
+	for i, hook := range hooks {
+		logger := log.WithFields(log.Fields{
+			"stage": stage,
+			"index": i,
+			"hook":  hookName(hook),
+		})
+
+		result := make(chan error, 1)
+		go func(hook Hook) {
+			result <- hook(ctx)
+		}(hook)
+
+		var err error
+		select {
+		case err = <-result:
+			if err != nil {
+				logger.WithField("error", err.Error()).Error("Hook failed")
+				err = errors.Annotatef(err, "%s hook %d failed", stage, i)
+			}
+
+		case <-ctx.Done():
+			logger.Error("Hook timed out")
+			err = errors.Errorf("%s hook %d timed out", stage, i)
+		}
+
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			if stopOnError || ctx.Err() != nil {
+				return first
+			}
+		}
+	}
+
+	return first
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunHooksOrder(t *testing.T) {
+	var calls []int
+	hooks := []Hook{
+		func(ctx context.Context) error { calls = append(calls, 1); return nil },
+		func(ctx context.Context) error { calls = append(calls, 2); return nil },
+		func(ctx context.Context) error { calls = append(calls, 3); return nil },
+	}
+
+	require.NoError(t, runHooks(context.Background(), "start", hooks, true))
+	require.Equal(t, calls, []int{1, 2, 3})
+}
+
+func TestRunHooksStopOnError(t *testing.T) {
+	var calls []int
+	hooks := []Hook{
+		func(ctx context.Context) error { calls = append(calls, 1); return errors.New("foo") },
+		func(ctx context.Context) error { calls = append(calls, 2); return nil },
+	}
+
+	require.EqualError(t, runHooks(context.Background(), "start", hooks, true), "start hook 0 failed: foo")
+	require.Equal(t, calls, []int{1})
+}
+
+func TestRunHooksContinueOnError(t *testing.T) {
+	var calls []int
+	hooks := []Hook{
+		func(ctx context.Context) error { calls = append(calls, 1); return errors.New("foo") },
+		func(ctx context.Context) error { calls = append(calls, 2); return errors.New("bar") },
+	}
+
+	require.EqualError(t, runHooks(context.Background(), "shutdown", hooks, false), "shutdown hook 0 failed: foo")
+	require.Equal(t, calls, []int{1, 2})
+}
+
+func TestRunHooksTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	var calls []int
+	hooks := []Hook{
+		func(ctx context.Context) error { time.Sleep(time.Second); return nil },
+		func(ctx context.Context) error { calls = append(calls, 2); return nil },
+	}
+
+	require.EqualError(t, runHooks(ctx, "shutdown", hooks, false), "shutdown hook 0 timed out")
+	require.Empty(t, calls)
+}
+
+func TestShutdownHooksReverseOrder(t *testing.T) {
+	service := Init("test")
+
+	var calls []int
+	service.OnShutdown(func(ctx context.Context) error { calls = append(calls, 1); return nil })
+	service.OnShutdown(func(ctx context.Context) error { calls = append(calls, 2); return nil })
+
+	require.NoError(t, service.runShutdownHooks())
+	require.Equal(t, calls, []int{2, 1})
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"math/rand"
 	"net"
@@ -9,6 +10,7 @@ import (
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -16,55 +18,122 @@ import (
 	_ "net/http/pprof"
 
 	"cloud.google.com/go/profiler"
-	"contrib.go.opencensus.io/exporter/stackdriver"
 	"github.com/altipla-consulting/routing"
+	raven "github.com/getsentry/raven-go"
+	"github.com/juju/errors"
 	log "github.com/sirupsen/logrus"
 	"go.opencensus.io/plugin/ocgrpc"
 	"go.opencensus.io/trace"
 	gotrace "golang.org/x/net/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 // Service stores the configuration of the service we are configuring.
 type Service struct {
 	name string
 
-	enableSentry bool
-	sentryDSN    string
+	enableSentry    bool
+	sentryDSN       string
+	sentryInTests   bool
+	sentryTransport raven.Transport
 
 	enableRouting       bool
 	routingServer       *routing.Server
 	routingServerCalled bool
-	routingHTTPServer   *http.Server
 	routingOpts         []routing.ServerOption
 
 	enableProfiler bool
 
-	enableTracer        bool
-	tracerGoogleProject string
-	traceExporter       *stackdriver.Exporter
+	enableMetrics bool
+	metrics       *serverMetrics
+
+	enableTracer   bool
+	tracingBackend TracingBackend
+	traceExporter  TraceExporter
+	samplerConfig  SamplerConfig
+	sampler        *customSampler
+
+	enableTailSampling bool
+	tailLatency        time.Duration
 
 	enableGRPC       bool
 	grpcServer       *grpc.Server
 	grpcServerCalled bool
-
-	debugHTTPServer *http.Server
+	grpcHealth       *grpcHealthServer
+	grpcCertFile     string
+	grpcKeyFile      string
+	grpcClientCAFile string
+	grpcCerts        *certReloader
+
+	grpcAuth          TokenVerifier
+	grpcPublicMethods []string
+	grpcUnary         []grpc.UnaryServerInterceptor
+	grpcStream        []grpc.StreamServerInterceptor
+	grpcOpts          []grpc.ServerOption
+
+	httpAddr      string
+	grpcAddr      string
+	debugAddr     string
+	httpListener  net.Listener
+	grpcListener  net.Listener
+	debugListener net.Listener
+
+	health *healthChecker
+
+	startHooks           []Hook
+	shutdownHooks        []Hook
+	startTimeout         time.Duration
+	shutdownHooksTimeout time.Duration
+
+	drainDelay             time.Duration
+	routingShutdownTimeout time.Duration
+	grpcShutdownTimeout    time.Duration
+	debugShutdownTimeout   time.Duration
+
+	// ran is set by the first run, the stopped servers cannot serve again.
+	ran int32
 }
 
 // Init the configuration of a new service for the current application with
 // the provided name.
-func Init(name string) *Service {
-	return &Service{
-		name: name,
+func Init(name string, opts ...Option) *Service {
+	service := &Service{
+		name:      name,
+		httpAddr:  envAddr("PORT", ":8080"),
+		grpcAddr:  envAddr("GRPC_PORT", ":9000"),
+		debugAddr: envAddr("DEBUG_PORT", ":8000"),
+		health:    newHealthChecker(),
+
+		startTimeout:         1 * time.Minute,
+		shutdownHooksTimeout: 30 * time.Second,
+
+		routingShutdownTimeout: 20 * time.Second,
+		grpcShutdownTimeout:    20 * time.Second,
+		debugShutdownTimeout:   3 * time.Second,
 	}
+	for _, opt := range opts {
+		opt(service)
+	}
+
+	return service
 }
 
 // ConfigureSentry enables Sentry support in all the features that support it.
+// It is disabled when running the tests unless the service was created with
+// WithSentryInTests or WithSentryTransport.
 func (service *Service) ConfigureSentry(dsn string) {
-	if dsn != "" {
-		service.enableSentry = true
-		service.sentryDSN = dsn
+	if dsn == "" {
+		return
+	}
+	if Environment() == EnvTest && !service.sentryInTests && service.sentryTransport == nil {
+		log.Info("Sentry disabled in the tests")
+		return
 	}
+
+	service.enableSentry = true
+	service.sentryDSN = dsn
 }
 
 // ConfigureRouting enables a HTTP router with the custom options we need. Logrus will
@@ -83,22 +152,76 @@ func (service *Service) ConfigureBetaRouting(username, password string) {
 	service.ConfigureRouting(routing.WithBetaAuth(username, password))
 }
 
-// ConfigureProfiler enables the Stackdriver Profiler agent.
+// ConfigureProfiler enables the Stackdriver Profiler agent. It is only enabled
+// in production.
 func (service *Service) ConfigureProfiler() {
-	service.enableProfiler = !IsLocal()
+	service.enableProfiler = IsProduction()
+}
+
+// ConfigureMetrics enables the /metrics endpoint in the debug server with the
+// Prometheus metrics of the routing and GRPC servers and the Go runtime. It should
+// be called before requesting the servers.
+func (service *Service) ConfigureMetrics() {
+	service.enableMetrics = true
+	service.metrics = newServerMetrics()
 }
 
-// ConfigureTracer enables the Stackdriver Trace agent.
-func (service *Service) ConfigureTracer(googleProject string) {
+// ConfigureTracer enables the Stackdriver Trace agent. It is only enabled in
+// staging and production.
+func (service *Service) ConfigureTracer(googleProject string, opts ...TracerOption) {
 	if googleProject != "" && !IsLocal() {
-		service.enableTracer = true
-		service.tracerGoogleProject = googleProject
+		service.ConfigureTracing(StackdriverTracing(googleProject), opts...)
 	}
 }
 
-// ConfigureGRPC enables a GRPC server.
-func (service *Service) ConfigureGRPC() {
+// ConfigureTracing enables the tracing of the servers, sending the traces to the
+// backend. It works in every environment, for example to debug the traces locally
+// with StdoutTracing.
+func (service *Service) ConfigureTracing(backend TracingBackend, opts ...TracerOption) {
+	service.enableTracer = true
+	service.tracingBackend = backend
+	for _, opt := range opts {
+		opt(service)
+	}
+}
+
+// ConfigureGRPC enables a GRPC server. The options can add interceptors, raw
+// server options, TLS and authentication. It should be called before requesting
+// the server.
+func (service *Service) ConfigureGRPC(opts ...GRPCOption) {
 	service.enableGRPC = true
+	for _, opt := range opts {
+		opt(service)
+	}
+}
+
+// AddHealthCheck registers a new check that should pass before the instance is
+// ready to receive traffic. All of them are reported in the /readyz endpoint of
+// the debug server.
+func (service *Service) AddHealthCheck(name string, check HealthCheck) {
+	service.health.addReadiness(name, check)
+}
+
+// AddLivenessCheck registers a new check that should pass while the instance is
+// alive. If it fails the instance will be restarted, so it should never depend
+// on external services. All of them are reported in the /healthz endpoint of
+// the debug server.
+func (service *Service) AddLivenessCheck(name string, check HealthCheck) {
+	service.health.addLiveness(name, check)
+}
+
+// OnStart registers a hook that will run before the servers start listening.
+// Hooks run in the same order they were registered and the first one that fails
+// stops the service. The shutdown hooks run then to release what the previous
+// start hooks opened, even the ones paired with hooks that did not run.
+func (service *Service) OnStart(hook Hook) {
+	service.startHooks = append(service.startHooks, hook)
+}
+
+// OnShutdown registers a hook that will run after the servers stop when the
+// instance is asked to exit. Hooks run in the reverse order they were registered.
+func (service *Service) OnShutdown(hook Hook) {
+	service.shutdownHooks = append(service.shutdownHooks, hook)
 }
 
 // GRPCServer returns the server to register new GRPC services on it.
@@ -108,15 +231,54 @@ func (service *Service) GRPCServer() *grpc.Server {
 	}
 
 	if service.grpcServer == nil {
+		var unary []grpc.UnaryServerInterceptor
+		var stream []grpc.StreamServerInterceptor
+		if service.enableMetrics {
+			unary = append(unary, service.metrics.unaryInterceptor())
+			stream = append(stream, service.metrics.streamInterceptor())
+		}
+		// Rejected calls are counted in the metrics but they do not reach the error
+		// logger, the authenticator logs them itself.
+		if service.grpcAuth != nil {
+			auth := newAuthenticator(service.grpcAuth, service.grpcPublicMethods)
+			unary = append(unary, auth.unaryInterceptor())
+			stream = append(stream, auth.streamInterceptor())
+		}
+		build := service.BuildInfo()
+		reporter := newSentryReporter(service.sentryDSN, build, service.sentryTransport)
+		unary = append(unary, grpcUnaryErrorLogger(build, reporter))
+		stream = append(stream, grpcStreamErrorLogger(build, reporter))
+		unary = append(unary, service.grpcUnary...)
+		stream = append(stream, service.grpcStream...)
+
 		opts := []grpc.ServerOption{
-			grpc.UnaryInterceptor(grpcUnaryErrorLogger(service.enableTracer, service.name, service.sentryDSN)),
-			grpc.StreamInterceptor(grpcStreamErrorLogger(service.name, service.sentryDSN)),
+			grpc.ChainUnaryInterceptor(unary...),
+			grpc.ChainStreamInterceptor(stream...),
 		}
 		if service.enableTracer {
-			opts = append(opts, grpc.StatsHandler(new(ocgrpc.ServerHandler)))
+			opts = append(opts, grpc.StatsHandler(&serverTraceHandler{
+				ServerHandler: new(ocgrpc.ServerHandler),
+				build:         build,
+			}))
+		}
+		if service.grpcClientCAFile != "" && service.grpcCertFile == "" {
+			panic("grpc client CA needs the server certificates configured with WithTLS")
+		}
+		if service.grpcCertFile != "" {
+			service.grpcCerts = &certReloader{
+				certFile: service.grpcCertFile,
+				keyFile:  service.grpcKeyFile,
+				caFile:   service.grpcClientCAFile,
+			}
+			config := &tls.Config{GetConfigForClient: service.grpcCerts.serverConfig}
+			opts = append(opts, grpc.Creds(credentials.NewTLS(config)))
 		}
+		opts = append(opts, service.grpcOpts...)
 
 		service.grpcServer = grpc.NewServer(opts...)
+
+		service.grpcHealth = newGRPCHealthServer(service.health)
+		healthpb.RegisterHealthServer(service.grpcServer, service.grpcHealth)
 	}
 
 	service.grpcServerCalled = true
@@ -133,7 +295,11 @@ func (service *Service) RoutingServer() *routing.Server {
 	if service.routingServer == nil {
 		opts := []routing.ServerOption{
 			routing.WithLogrus(),
-			routing.WithSentry(service.sentryDSN),
+		}
+		// The routing server reports with its own client that cannot use the
+		// transport of the tests.
+		if service.sentryTransport == nil {
+			opts = append(opts, routing.WithSentry(service.sentryDSN))
 		}
 		opts = append(opts, service.routingOpts...)
 		service.routingServer = routing.NewServer(opts...)
@@ -144,8 +310,37 @@ func (service *Service) RoutingServer() *routing.Server {
 	return service.routingServer
 }
 
-// Run starts listening in every configure port needed to provide the configured features.
+// Run starts listening in every configure port needed to provide the configured
+// features. It blocks until the process receives a SIGTERM or SIGINT signal and
+// exits the program if any of the servers fails.
 func (service *Service) Run() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	gracefulStop := make(chan os.Signal, 1)
+	signal.Notify(gracefulStop, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-gracefulStop
+		log.WithField("signal", sig).Info("Caught OS signal")
+		cancel()
+	}()
+
+	if err := service.RunContext(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// RunContext starts listening in every configure port needed to provide the configured
+// features. It blocks until the context is cancelled or any of the servers fails.
+// Then it stops the instance gracefully and returns the first error found.
+//
+// A service runs only once, the stopped servers cannot serve again. Call Init
+// again to get a new one.
+func (service *Service) RunContext(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&service.ran, 0, 1) {
+		return errors.New("the service already ran, initialize a new one to run it again")
+	}
+
 	rand.Seed(time.Now().UTC().UnixNano())
 
 	if service.enableRouting && !service.routingServerCalled {
@@ -155,6 +350,127 @@ func (service *Service) Run() {
 		panic("do not configure grpc without services")
 	}
 
+	addrs := []listenAddr{
+		{server: "debug", addr: service.debugAddr, listener: service.debugListener},
+	}
+	if service.enableRouting {
+		addrs = append(addrs, listenAddr{server: "routing", addr: service.httpAddr, listener: service.httpListener})
+	} else if service.httpListener != nil {
+		service.httpListener.Close()
+	}
+	if service.enableGRPC {
+		addrs = append(addrs, listenAddr{server: "grpc", addr: service.grpcAddr, listener: service.grpcListener})
+	} else if service.grpcListener != nil {
+		service.grpcListener.Close()
+	}
+	listeners, err := listenAll(addrs)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if err := service.start(); err != nil {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+		return errors.Trace(err)
+	}
+
+	failures := make(chan error, len(listeners))
+
+	// Every server is read from a local variable and the goroutines are waited
+	// before returning, nothing of this run outlives it.
+	var serving sync.WaitGroup
+	var routingHTTPServer *http.Server
+	if service.enableRouting {
+		var handler http.Handler
+		if service.enableTracer {
+			handler = traceHTTP(service.routingServer.Router(), newBuildInfo(service.name))
+		} else {
+			handler = service.routingServer.Router()
+		}
+		if service.enableMetrics {
+			handler = service.metrics.instrumentHTTP(handler)
+		}
+		routingHTTPServer = &http.Server{
+			Handler: handler,
+		}
+		serving.Add(1)
+		go func() {
+			defer serving.Done()
+			log.WithField("addr", service.httpAddr).Info("Routing server enabled")
+
+			if err := routingHTTPServer.Serve(listeners["routing"]); err != nil && err != http.ErrServerClosed {
+				failures <- errors.Annotate(err, "routing server failed")
+			}
+		}()
+	}
+
+	if service.enableGRPC {
+		var names []string
+		for name := range service.grpcServer.GetServiceInfo() {
+			names = append(names, name)
+		}
+		service.grpcHealth.markServing(names)
+
+		grpcServer := service.grpcServer
+		serving.Add(1)
+		go func() {
+			defer serving.Done()
+			log.WithField("addr", service.grpcAddr).Info("GRPC server enabled")
+
+			if err := grpcServer.Serve(listeners["grpc"]); err != nil {
+				failures <- errors.Annotate(err, "grpc server failed")
+			}
+		}()
+	}
+
+	gotrace.AuthRequest = func(req *http.Request) (any, sensitive bool) { return true, true }
+
+	// Use a custom mux to allow multiple runs in the same process. Pprof and the
+	// traces register themselves in the default one.
+	mux := http.NewServeMux()
+	mux.Handle("/", http.DefaultServeMux)
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) { fmt.Fprintf(w, "%s is ok\n", service.name) })
+	mux.HandleFunc("/healthz", service.health.livenessHandler)
+	mux.HandleFunc("/readyz", service.health.readinessHandler)
+	mux.HandleFunc("/version", service.versionHandler)
+	if service.enableMetrics {
+		mux.Handle("/metrics", service.metrics.handler())
+	}
+	if service.enableTracer {
+		mux.HandleFunc("/debug/sampler", service.sampler.debugHandler)
+	}
+	debugHTTPServer := &http.Server{
+		Handler: mux,
+	}
+	serving.Add(1)
+	go func() {
+		defer serving.Done()
+
+		if err := debugHTTPServer.Serve(listeners["debug"]); err != nil && err != http.ErrServerClosed {
+			failures <- errors.Annotate(err, "debug server failed")
+		}
+	}()
+
+	log.WithFields(service.BuildInfo().logFields()).Println("Instance initialized successfully!")
+
+	var first error
+	select {
+	case <-ctx.Done():
+	case first = <-failures:
+		log.WithField("error", first.Error()).Error("Server failed, stopping the instance")
+	}
+
+	if err := service.shutdown(routingHTTPServer, debugHTTPServer); err != nil && first == nil {
+		first = err
+	}
+	serving.Wait()
+
+	return errors.Trace(first)
+}
+
+// start prepares all the global features and runs the start hooks.
+func (service *Service) start() error {
 	if service.enableSentry {
 		log.WithField("dsn", service.sentryDSN).Info("Sentry enabled")
 	}
@@ -167,123 +483,172 @@ func (service *Service) Run() {
 			ServiceVersion: Version(),
 		}
 		if err := profiler.Start(cnf); err != nil {
-			log.Fatal(err)
+			return errors.Trace(err)
+		}
+	}
+
+	// Fail early if the certificates cannot be read instead of waiting for the
+	// first connection.
+	if service.grpcCerts != nil {
+		if err := service.grpcCerts.load(); err != nil {
+			return errors.Trace(err)
 		}
 	}
 
 	if service.enableTracer {
-		log.WithField("project", service.tracerGoogleProject).Info("Stackdriver Trace enabled")
+		log.WithField("backend", service.tracingBackend.name).Info("Tracing enabled")
 
 		var err error
-		service.traceExporter, err = stackdriver.NewExporter(stackdriver.Options{ProjectID: service.tracerGoogleProject})
+		service.traceExporter, err = service.tracingBackend.build(service.name)
 		if err != nil {
-			log.Fatal(err)
+			return errors.Trace(err)
+		}
+
+		// The sampler is complete before it is installed, its closure reads the
+		// tail sampler on every span.
+		sampler := newCustomSampler(service.samplerConfig)
+		if service.enableTailSampling {
+			sampler.tail = newTailSampler(service.traceExporter, service.tailLatency)
+			service.traceExporter = sampler.tail
+			setActiveTailSampler(sampler.tail)
+		}
+		service.sampler = sampler
+		if service.enableMetrics {
+			service.metrics.registry.MustRegister(service.sampler)
 		}
 		trace.RegisterExporter(service.traceExporter)
 
-		sampler := newCustomSampler()
 		trace.ApplyConfig(trace.Config{
-			DefaultSampler: sampler.Sampler(),
+			DefaultSampler: service.sampler.Sampler(),
 		})
 	}
 
-	if service.enableRouting {
-		go func() {
-			log.Info("Routing server enabled")
-
-			service.routingHTTPServer = &http.Server{
-				Addr:    ":8080",
-				Handler: service.routingServer.Router(),
-			}
-			if err := service.routingHTTPServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				log.Fatal(err)
-			}
-		}()
+	if err := service.runStartHooks(); err != nil {
+		service.undoStart()
+		return errors.Trace(err)
 	}
 
-	if service.enableGRPC {
-		go func() {
-			log.Info("GRPC server enabled")
+	return nil
+}
 
-			listener, err := net.Listen("tcp", ":9000")
-			if err != nil {
-				log.Fatal(err)
-			}
-			log.Fatal(service.grpcServer.Serve(listener))
-		}()
+// undoStart releases everything prepared by start in the reverse order when the
+// start hooks fail.
+func (service *Service) undoStart() {
+	if err := service.runShutdownHooks(); err != nil {
+		log.WithField("error", err.Error()).Error("Cannot run the shutdown hooks after a failed start")
 	}
 
-	gotrace.AuthRequest = func(req *http.Request) (any, sensitive bool) { return true, true }
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) { fmt.Fprintf(w, "%s is ok\n", service.name) })
-
-	service.stopListener()
-
-	log.WithField("name", service.name).Println("Instance initialized successfully!")
-
-	service.debugHTTPServer = &http.Server{
-		Addr: ":8000",
-	}
-	if err := service.debugHTTPServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatal(err)
+	if service.enableTracer {
+		// Default sampler of OpenCensus.
+		trace.ApplyConfig(trace.Config{DefaultSampler: trace.ProbabilitySampler(1e-4)})
+		service.traceExporter.Flush()
+		trace.UnregisterExporter(service.traceExporter)
+		if service.enableMetrics {
+			service.metrics.registry.Unregister(service.sampler)
+		}
+		if service.enableTailSampling {
+			setActiveTailSampler(nil)
+		}
 	}
 }
 
-func (service *Service) stopListener() {
-	var gracefulStop = make(chan os.Signal)
-	signal.Notify(gracefulStop, syscall.SIGTERM)
-	signal.Notify(gracefulStop, syscall.SIGINT)
+// shutdown stops all the servers gracefully and runs the shutdown hooks.
+func (service *Service) shutdown(routingHTTPServer, debugHTTPServer *http.Server) error {
+	log.WithField("name", service.name).Info("Stopping the instance")
 
-	go func() {
-		sig := <-gracefulStop
-		log.WithField("signal", sig).Info("Caught OS signal")
+	service.health.startDraining()
+	if service.enableGRPC {
+		service.grpcHealth.startDraining()
+	}
 
-		var wg sync.WaitGroup
+	// Give time to the load balancers to notice the readiness change before
+	// closing the connections.
+	if service.drainDelay > 0 {
+		log.WithField("delay", service.drainDelay.String()).Info("Draining connections")
+		time.Sleep(service.drainDelay)
+	}
 
-		if service.enableGRPC {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
+	var wg sync.WaitGroup
 
-				service.grpcServer.GracefulStop()
-			}()
-		}
+	if service.enableGRPC {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
 
-		if service.enableTracer {
-			wg.Add(1)
+			stopped := make(chan struct{})
 			go func() {
-				defer wg.Done()
-
-				service.traceExporter.Flush()
+				service.grpcServer.GracefulStop()
+				close(stopped)
 			}()
-		}
 
-		if service.enableRouting {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
+			select {
+			case <-stopped:
+			case <-time.After(service.grpcShutdownTimeout):
+				log.Error("Cannot shutdown GRPC server gracefully, closing all connections")
+				service.grpcServer.Stop()
+			}
+		}()
+	}
 
-				ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-				defer cancel()
+	if service.enableTracer {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
 
-				if err := service.routingHTTPServer.Shutdown(ctx); err != nil {
-					log.WithField("error", err).Error("Cannot shutdown routing HTTP server")
-				}
-			}()
-		}
+			service.traceExporter.Flush()
+			trace.UnregisterExporter(service.traceExporter)
+			if service.enableTailSampling {
+				setActiveTailSampler(nil)
+			}
+		}()
+	}
 
+	if service.enableRouting {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 
-			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			ctx, cancel := context.WithTimeout(context.Background(), service.routingShutdownTimeout)
 			defer cancel()
 
-			if err := service.debugHTTPServer.Shutdown(ctx); err != nil {
-				log.WithField("error", err).Error("Cannot shutdown debug HTTP server")
+			if err := routingHTTPServer.Shutdown(ctx); err != nil {
+				log.WithField("error", err).Error("Cannot shutdown routing HTTP server")
 			}
 		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
 
-		wg.Wait()
-		os.Exit(0)
+		ctx, cancel := context.WithTimeout(context.Background(), service.debugShutdownTimeout)
+		defer cancel()
+
+		if err := debugHTTPServer.Shutdown(ctx); err != nil {
+			log.WithField("error", err).Error("Cannot shutdown debug HTTP server")
+		}
 	}()
+
+	wg.Wait()
+
+	return errors.Trace(service.runShutdownHooks())
+}
+
+func (service *Service) runStartHooks() error {
+	ctx, cancel := context.WithTimeout(context.Background(), service.startTimeout)
+	defer cancel()
+
+	return errors.Trace(runHooks(ctx, "start", service.startHooks, true))
+}
+
+func (service *Service) runShutdownHooks() error {
+	ctx, cancel := context.WithTimeout(context.Background(), service.shutdownHooksTimeout)
+	defer cancel()
+
+	hooks := make([]Hook, len(service.shutdownHooks))
+	for i, hook := range service.shutdownHooks {
+		hooks[len(hooks)-i-1] = hook
+	}
+
+	return errors.Trace(runHooks(ctx, "shutdown", hooks, false))
 }
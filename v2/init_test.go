@@ -0,0 +1,116 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/trace"
+)
+
+func TestRunContextCancel(t *testing.T) {
+	service := Init("test", WithDebugAddr("localhost:0"))
+
+	var started, stopped bool
+	service.OnStart(func(ctx context.Context) error { started = true; return nil })
+	service.OnShutdown(func(ctx context.Context) error { stopped = true; return nil })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- service.RunContext(ctx)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not return after cancelling the context")
+	}
+
+	require.True(t, started)
+	require.True(t, stopped)
+}
+
+func TestRunContextTwice(t *testing.T) {
+	defer trace.ApplyConfig(trace.Config{DefaultSampler: trace.ProbabilitySampler(1e-4)})
+
+	service := Init("test", WithDebugAddr("localhost:0"))
+	service.ConfigureMetrics()
+	service.ConfigureTracing(StdoutTracing(ioutil.Discard), WithTailSampling(time.Second))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, service.RunContext(ctx))
+	require.EqualError(t, service.RunContext(ctx), "the service already ran, initialize a new one to run it again")
+
+	// Another service in the same process registers its own sampler.
+	service = Init("test", WithDebugAddr("localhost:0"))
+	service.ConfigureMetrics()
+	service.ConfigureTracing(StdoutTracing(ioutil.Discard), WithTailSampling(time.Second))
+	require.NoError(t, service.RunContext(ctx))
+}
+
+func TestRunContextStartHookFails(t *testing.T) {
+	service := Init("test", WithDebugAddr("localhost:0"))
+	service.OnStart(func(ctx context.Context) error { return errors.New("foo") })
+
+	require.EqualError(t, service.RunContext(context.Background()), "start hook 0 failed: foo")
+}
+
+func TestRunContextStartHookFailsUndo(t *testing.T) {
+	var buf bytes.Buffer
+	service := Init("test", WithDebugAddr("localhost:0"))
+	service.ConfigureMetrics()
+	service.ConfigureTracing(StdoutTracing(&buf), WithTailSampling(time.Second))
+
+	var calls []string
+	service.OnStart(func(ctx context.Context) error { calls = append(calls, "start 0"); return nil })
+	service.OnStart(func(ctx context.Context) error { return errors.New("foo") })
+	service.OnShutdown(func(ctx context.Context) error { calls = append(calls, "shutdown 0"); return nil })
+	service.OnShutdown(func(ctx context.Context) error { calls = append(calls, "shutdown 1"); return nil })
+
+	require.EqualError(t, service.RunContext(context.Background()), "start hook 1 failed: foo")
+	require.Equal(t, calls, []string{"start 0", "shutdown 1", "shutdown 0"})
+
+	activeTailMu.RLock()
+	require.Nil(t, activeTail)
+	activeTailMu.RUnlock()
+
+	// The exporter does not receive spans anymore.
+	_, span := trace.StartSpan(context.Background(), "test", trace.WithSampler(trace.AlwaysSample()))
+	span.End()
+	require.Empty(t, buf.String())
+
+	// The metrics accept the sampler of the next service.
+	require.NoError(t, service.metrics.registry.Register(newCustomSampler(service.samplerConfig)))
+}
+
+func TestRunContextPortInUse(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	service := Init("test", WithDebugAddr(listener.Addr().String()))
+
+	require.Error(t, service.RunContext(context.Background()))
+}
+
+func TestConfigureSentryInTests(t *testing.T) {
+	service := Init("test")
+	service.ConfigureSentry("https://key@sentry.example.com/1")
+	require.False(t, service.enableSentry)
+
+	service = Init("test", WithSentryInTests())
+	service.ConfigureSentry("https://key@sentry.example.com/1")
+	require.True(t, service.enableSentry)
+	require.Equal(t, service.sentryDSN, "https://key@sentry.example.com/1")
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"net"
+	"os"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// envAddr reads the port or address from the environment variable, returning the
+// default address if it is not present.
+func envAddr(name, def string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	if !strings.Contains(value, ":") {
+		return ":" + value
+	}
+	return value
+}
+
+type listenAddr struct {
+	server   string
+	addr     string
+	listener net.Listener
+}
+
+// checkConflicts returns an error if two servers try to bind the same port in
+// a compatible host.
+func checkConflicts(addrs []listenAddr) error {
+	var pending []listenAddr
+	for _, addr := range addrs {
+		if addr.listener == nil {
+			pending = append(pending, addr)
+		}
+	}
+
+	for i, a := range pending {
+		hostA, portA, err := net.SplitHostPort(a.addr)
+		if err != nil {
+			return errors.Annotatef(err, "invalid address for the %s server", a.server)
+		}
+
+		for _, b := range pending[i+1:] {
+			hostB, portB, err := net.SplitHostPort(b.addr)
+			if err != nil {
+				return errors.Annotatef(err, "invalid address for the %s server", b.server)
+			}
+
+			if portA != portB || portA == "0" {
+				continue
+			}
+			if hostA == hostB || isWildcardHost(hostA) || isWildcardHost(hostB) {
+				return errors.Errorf("the %s server (%s) and the %s server (%s) cannot listen in the same port", a.server, a.addr, b.server, b.addr)
+			}
+		}
+	}
+
+	return nil
+}
+
+func isWildcardHost(host string) bool {
+	return host == "" || host == "0.0.0.0" || host == "::"
+}
+
+// listenAll opens a listener for every address at once, reusing the ones that
+// are already provided. If any of them fails all of them are closed before
+// returning the error.
+func listenAll(addrs []listenAddr) (map[string]net.Listener, error) {
+	closeAll := func(listeners map[string]net.Listener) {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+		for _, addr := range addrs {
+			if _, ok := listeners[addr.server]; !ok && addr.listener != nil {
+				addr.listener.Close()
+			}
+		}
+	}
+
+	if err := checkConflicts(addrs); err != nil {
+		closeAll(nil)
+		return nil, errors.Trace(err)
+	}
+
+	listeners := make(map[string]net.Listener)
+	for _, addr := range addrs {
+		if addr.listener != nil {
+			listeners[addr.server] = addr.listener
+			continue
+		}
+
+		listener, err := net.Listen("tcp", addr.addr)
+		if err != nil {
+			closeAll(listeners)
+			return nil, errors.Annotatef(err, "cannot listen in %s for the %s server", addr.addr, addr.server)
+		}
+		listeners[addr.server] = listener
+	}
+
+	return listeners, nil
+}
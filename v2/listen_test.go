@@ -0,0 +1,61 @@
+package services
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvAddrDefault(t *testing.T) {
+	os.Unsetenv("TEST_PORT")
+
+	require.Equal(t, envAddr("TEST_PORT", ":8080"), ":8080")
+}
+
+func TestEnvAddrPort(t *testing.T) {
+	os.Setenv("TEST_PORT", "3000")
+	defer os.Unsetenv("TEST_PORT")
+
+	require.Equal(t, envAddr("TEST_PORT", ":8080"), ":3000")
+}
+
+func TestEnvAddrHost(t *testing.T) {
+	os.Setenv("TEST_PORT", "127.0.0.1:3000")
+	defer os.Unsetenv("TEST_PORT")
+
+	require.Equal(t, envAddr("TEST_PORT", ":8080"), "127.0.0.1:3000")
+}
+
+func TestCheckConflictsDifferentPorts(t *testing.T) {
+	addrs := []listenAddr{
+		{server: "debug", addr: ":8000"},
+		{server: "routing", addr: ":8080"},
+		{server: "grpc", addr: ":9000"},
+	}
+	require.NoError(t, checkConflicts(addrs))
+}
+
+func TestCheckConflictsSamePort(t *testing.T) {
+	addrs := []listenAddr{
+		{server: "debug", addr: ":8000"},
+		{server: "grpc", addr: "0.0.0.0:8000"},
+	}
+	require.EqualError(t, checkConflicts(addrs), "the debug server (:8000) and the grpc server (0.0.0.0:8000) cannot listen in the same port")
+}
+
+func TestCheckConflictsDifferentHosts(t *testing.T) {
+	addrs := []listenAddr{
+		{server: "debug", addr: "127.0.0.1:8000"},
+		{server: "grpc", addr: "10.0.0.1:8000"},
+	}
+	require.NoError(t, checkConflicts(addrs))
+}
+
+func TestCheckConflictsEphemeralPorts(t *testing.T) {
+	addrs := []listenAddr{
+		{server: "debug", addr: "localhost:0"},
+		{server: "grpc", addr: "localhost:0"},
+	}
+	require.NoError(t, checkConflicts(addrs))
+}
@@ -5,12 +5,22 @@ import (
 )
 
 func init() {
-	if IsLocal() {
+	switch Environment() {
+	case EnvLocal:
 		log.SetFormatter(&log.TextFormatter{
 			ForceColors: true,
 		})
 		log.SetLevel(log.DebugLevel)
-	} else {
+
+	case EnvTest:
+		log.SetFormatter(new(log.TextFormatter))
+		log.SetLevel(log.DebugLevel)
+
+	case EnvStaging, EnvPreview:
+		log.SetFormatter(new(log.JSONFormatter))
+		log.SetLevel(log.DebugLevel)
+
+	default:
 		log.SetFormatter(new(log.JSONFormatter))
 	}
 }
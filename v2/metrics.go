@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+type serverMetrics struct {
+	registry *prometheus.Registry
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+	httpInFlight prometheus.Gauge
+
+	grpcHandled  *prometheus.CounterVec
+	grpcDuration *prometheus.HistogramVec
+	grpcInFlight *prometheus.GaugeVec
+}
+
+func newServerMetrics() *serverMetrics {
+	metrics := &serverMetrics{
+		registry: prometheus.NewRegistry(),
+
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Total number of HTTP requests handled by the routing server.",
+		}, []string{"method", "code"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Latency of the HTTP requests handled by the routing server.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "code"}),
+		httpInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "Number of HTTP requests being handled by the routing server.",
+		}),
+
+		grpcHandled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_handled_total",
+			Help: "Total number of RPCs completed by the GRPC server.",
+		}, []string{"grpc_type", "grpc_method", "grpc_code"}),
+		grpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "grpc_server_handling_seconds",
+			Help:    "Latency of the RPCs handled by the GRPC server.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"grpc_type", "grpc_method"}),
+		grpcInFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "grpc_server_in_flight",
+			Help: "Number of RPCs being handled by the GRPC server.",
+		}, []string{"grpc_type", "grpc_method"}),
+	}
+	metrics.registry.MustRegister(
+		metrics.httpRequests,
+		metrics.httpDuration,
+		metrics.httpInFlight,
+		metrics.grpcHandled,
+		metrics.grpcDuration,
+		metrics.grpcInFlight,
+	)
+
+	return metrics
+}
+
+// handler serves the metrics of the server together with the ones registered
+// globally by the application and the Go runtime.
+func (metrics *serverMetrics) handler() http.Handler {
+	gatherers := prometheus.Gatherers{
+		prometheus.DefaultGatherer,
+		metrics.registry,
+	}
+	return promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{})
+}
+
+func (metrics *serverMetrics) instrumentHTTP(handler http.Handler) http.Handler {
+	handler = promhttp.InstrumentHandlerCounter(metrics.httpRequests, handler)
+	handler = promhttp.InstrumentHandlerDuration(metrics.httpDuration, handler)
+	return promhttp.InstrumentHandlerInFlight(metrics.httpInFlight, handler)
+}
+
+func (metrics *serverMetrics) observeRPC(rpcType, method string, start time.Time, err error) {
+	metrics.grpcHandled.WithLabelValues(rpcType, method, status.Code(err).String()).Inc()
+	metrics.grpcDuration.WithLabelValues(rpcType, method).Observe(time.Since(start).Seconds())
+}
+
+func (metrics *serverMetrics) unaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		inFlight := metrics.grpcInFlight.WithLabelValues("unary", info.FullMethod)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		metrics.observeRPC("unary", info.FullMethod, start, err)
+
+		return resp, err
+	}
+}
+
+func (metrics *serverMetrics) streamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		rpcType := "bidi_stream"
+		switch {
+		case info.IsClientStream && !info.IsServerStream:
+			rpcType = "client_stream"
+		case !info.IsClientStream && info.IsServerStream:
+			rpcType = "server_stream"
+		}
+
+		inFlight := metrics.grpcInFlight.WithLabelValues(rpcType, info.FullMethod)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		start := time.Now()
+		err := handler(srv, stream)
+		metrics.observeRPC(rpcType, info.FullMethod, start, err)
+
+		return err
+	}
+}
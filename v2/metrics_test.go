@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func scrapeMetrics(t *testing.T, metrics *serverMetrics) string {
+	w := httptest.NewRecorder()
+	metrics.handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, w.Code, http.StatusOK)
+
+	body, err := ioutil.ReadAll(w.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestMetricsHTTP(t *testing.T) {
+	metrics := newServerMetrics()
+	handler := metrics.instrumentHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/foo", nil))
+
+	body := scrapeMetrics(t, metrics)
+	require.Contains(t, body, `http_requests_total{code="404",method="get"} 1`)
+	require.Contains(t, body, `http_requests_in_flight 0`)
+	require.Contains(t, body, `go_goroutines`)
+}
+
+func TestMetricsUnaryRPC(t *testing.T) {
+	metrics := newServerMetrics()
+	interceptor := metrics.unaryInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/foo.Bar/Baz"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Errorf(codes.NotFound, "not found")
+	}
+
+	_, err := interceptor(context.Background(), nil, info, handler)
+	require.Error(t, err)
+
+	body := scrapeMetrics(t, metrics)
+	require.Contains(t, body, `grpc_server_handled_total{grpc_code="NotFound",grpc_method="/foo.Bar/Baz",grpc_type="unary"} 1`)
+}
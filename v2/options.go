@@ -0,0 +1,149 @@
+package services
+
+import (
+	"net"
+	"time"
+
+	raven "github.com/getsentry/raven-go"
+)
+
+// Option configures a service when calling Init.
+type Option func(service *Service)
+
+// WithHTTPAddr changes the address of the routing server. By default it listens
+// in :8080 or in the port of the environment variable PORT if present.
+func WithHTTPAddr(addr string) Option {
+	return func(service *Service) {
+		service.httpAddr = addr
+	}
+}
+
+// WithGRPCAddr changes the address of the GRPC server. By default it listens
+// in :9000 or in the port of the environment variable GRPC_PORT if present.
+func WithGRPCAddr(addr string) Option {
+	return func(service *Service) {
+		service.grpcAddr = addr
+	}
+}
+
+// WithDebugAddr changes the address of the debug server that exposes the health
+// checks, pprof and the traces. By default it listens in :8000 or in the port of
+// the environment variable DEBUG_PORT if present.
+func WithDebugAddr(addr string) Option {
+	return func(service *Service) {
+		service.debugAddr = addr
+	}
+}
+
+// WithHTTPListener serves the routing server in an already open listener instead
+// of the configured address. The service takes ownership of the listener and
+// will close it when stopping.
+func WithHTTPListener(listener net.Listener) Option {
+	return func(service *Service) {
+		service.httpListener = listener
+	}
+}
+
+// WithGRPCListener serves the GRPC server in an already open listener instead
+// of the configured address. The service takes ownership of the listener and
+// will close it when stopping.
+func WithGRPCListener(listener net.Listener) Option {
+	return func(service *Service) {
+		service.grpcListener = listener
+	}
+}
+
+// WithDebugListener serves the debug server in an already open listener instead
+// of the configured address. The service takes ownership of the listener and
+// will close it when stopping.
+func WithDebugListener(listener net.Listener) Option {
+	return func(service *Service) {
+		service.debugListener = listener
+	}
+}
+
+// WithHealthCheckTimeout changes the maximum time a health check can run before
+// failing. By default it is 5 seconds.
+func WithHealthCheckTimeout(timeout time.Duration) Option {
+	return func(service *Service) {
+		service.health.timeout = timeout
+	}
+}
+
+// WithHealthCheckCache changes the time the result of a health check is reused
+// before running it again. By default it is 2 seconds.
+func WithHealthCheckCache(cache time.Duration) Option {
+	return func(service *Service) {
+		service.health.cache = cache
+	}
+}
+
+// WithSentryInTests keeps the Sentry configuration when running the tests, for
+// example to check the reports with a fake DSN. By default ConfigureSentry is
+// ignored in the tests.
+func WithSentryInTests() Option {
+	return func(service *Service) {
+		service.sentryInTests = true
+	}
+}
+
+// WithSentryTransport sends the Sentry reports of the GRPC servers with the
+// transport instead of the network and keeps Sentry enabled in the tests. The
+// routing server reports with its own client, so it does not report to Sentry
+// when the transport is set. Package servicestest uses it to capture the reports.
+func WithSentryTransport(transport raven.Transport) Option {
+	return func(service *Service) {
+		service.sentryTransport = transport
+	}
+}
+
+// WithStartTimeout changes the global deadline to run all the hooks registered
+// with OnStart. By default it is 1 minute.
+func WithStartTimeout(timeout time.Duration) Option {
+	return func(service *Service) {
+		service.startTimeout = timeout
+	}
+}
+
+// WithShutdownHooksTimeout changes the global deadline to run all the hooks
+// registered with OnShutdown. It starts after the servers stop, that have their
+// own timeouts. By default it is 30 seconds.
+func WithShutdownHooksTimeout(timeout time.Duration) Option {
+	return func(service *Service) {
+		service.shutdownHooksTimeout = timeout
+	}
+}
+
+// WithDrainDelay waits the delay after the instance is marked as not ready and
+// before the servers stop accepting connections. It gives time to the load balancers
+// to stop sending new requests to the instance. By default there is no delay.
+func WithDrainDelay(delay time.Duration) Option {
+	return func(service *Service) {
+		service.drainDelay = delay
+	}
+}
+
+// WithRoutingShutdownTimeout changes the time the routing server waits for the
+// active requests when stopping. By default it is 20 seconds.
+func WithRoutingShutdownTimeout(timeout time.Duration) Option {
+	return func(service *Service) {
+		service.routingShutdownTimeout = timeout
+	}
+}
+
+// WithGRPCShutdownTimeout changes the time the GRPC server waits for the active
+// calls and streams when stopping. After that all the connections are closed
+// abruptly. By default it is 20 seconds.
+func WithGRPCShutdownTimeout(timeout time.Duration) Option {
+	return func(service *Service) {
+		service.grpcShutdownTimeout = timeout
+	}
+}
+
+// WithDebugShutdownTimeout changes the time the debug server waits for the active
+// requests when stopping. By default it is 3 seconds.
+func WithDebugShutdownTimeout(timeout time.Duration) Option {
+	return func(service *Service) {
+		service.debugShutdownTimeout = timeout
+	}
+}
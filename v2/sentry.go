@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	raven "github.com/getsentry/raven-go"
+	log "github.com/sirupsen/logrus"
+	"go.opencensus.io/trace"
+)
+
+// sentryReporter sends the errors of the GRPC servers to Sentry with the release,
+// environment and build of the binary.
+type sentryReporter struct {
+	client *raven.Client
+}
+
+// newSentryReporter returns nil if the DSN is empty; a nil reporter can be used
+// and it does nothing. The transport replaces the network one if it is not nil.
+func newSentryReporter(dsn string, build BuildInfo, transport raven.Transport) *sentryReporter {
+	if dsn == "" {
+		return nil
+	}
+
+	tags := map[string]string{"app": build.Name}
+	if build.Commit != "" {
+		tags["commit"] = build.Commit
+	}
+	client, err := raven.NewWithTags(dsn, tags)
+	if err != nil {
+		log.WithField("error", err.Error()).Error("Cannot configure Sentry")
+		return nil
+	}
+	client.SetRelease(build.release())
+	client.SetEnvironment(string(Environment()))
+	if transport != nil {
+		client.Transport = transport
+	}
+
+	return &sentryReporter{client: client}
+}
+
+// report sends the error with the tags of the call. The packet follows the
+// reports of the altipla sentry client, including the frames of the juju errors,
+// to keep the same grouping and searches in Sentry. The breadcrumbs of that client
+// cannot be read from the context and are not sent; the trace of the call is
+// linked instead.
+func (reporter *sentryReporter) report(ctx context.Context, err error, tags map[string]string) {
+	if reporter == nil {
+		return
+	}
+
+	if span := trace.FromContext(ctx); span != nil {
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags["trace"] = span.SpanContext().TraceID.String()
+	}
+
+	stacktrace := jujuStacktrace(err)
+	if stacktrace == nil {
+		stacktrace = raven.GetOrNewStacktrace(err, 2, 3, nil)
+	}
+	exception := &raven.Exception{
+		Value:      err.Error(),
+		Type:       err.Error(),
+		Module:     "backend",
+		Stacktrace: stacktrace,
+	}
+	packet := raven.NewPacket(err.Error(), exception)
+
+	reporter.client.Capture(packet, tags)
+}
+
+type jujuStacktracer interface {
+	StackTrace() []string
+}
+
+// jujuStacktrace returns the locations annotated by the juju errors, from the
+// oldest to the newest like Sentry expects them. It returns nil for other errors.
+func jujuStacktrace(err error) *raven.Stacktrace {
+	tracer, ok := err.(jujuStacktracer)
+	if !ok {
+		return nil
+	}
+
+	stacktrace := new(raven.Stacktrace)
+	entries := tracer.StackTrace()
+	for i := len(entries) - 1; i >= 0; i-- {
+		frame := &raven.StacktraceFrame{
+			Filename:    entries[i],
+			ContextLine: entries[i],
+		}
+		if parts := strings.Split(entries[i], ":"); len(parts) > 2 {
+			if n, err := strconv.Atoi(parts[1]); err == nil {
+				frame.Filename = parts[0]
+				frame.Lineno = n
+			}
+		}
+		stacktrace.Frames = append(stacktrace.Frames, frame)
+	}
+	return stacktrace
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	raven "github.com/getsentry/raven-go"
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingTransport struct {
+	mu      sync.Mutex
+	packets []*raven.Packet
+}
+
+func (transport *recordingTransport) Send(url, authHeader string, packet *raven.Packet) error {
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+
+	transport.packets = append(transport.packets, packet)
+	return nil
+}
+
+func TestSentryReport(t *testing.T) {
+	transport := new(recordingTransport)
+	reporter := newSentryReporter("https://key@sentry.example.com/1", BuildInfo{Name: "foo", Version: "v1", Commit: "abc"}, transport)
+
+	err := errors.Trace(errors.New("bar"))
+	reporter.report(context.Background(), err, map[string]string{
+		"rpc_service": "foo",
+		"rpc_method":  "/foo.Foo/Bar",
+	})
+	reporter.client.Wait()
+
+	require.Len(t, transport.packets, 1)
+	packet := transport.packets[0]
+	require.Equal(t, packet.Release, "v1")
+	require.Equal(t, packet.Environment, string(EnvTest))
+
+	tags := map[string]string{}
+	for _, tag := range packet.Tags {
+		tags[tag.Key] = tag.Value
+	}
+	require.Equal(t, tags, map[string]string{
+		"app":         "foo",
+		"commit":      "abc",
+		"rpc_service": "foo",
+		"rpc_method":  "/foo.Foo/Bar",
+	})
+
+	require.Len(t, packet.Interfaces, 1)
+	exception := packet.Interfaces[0].(*raven.Exception)
+	require.Equal(t, exception.Type, "bar")
+	require.Len(t, exception.Stacktrace.Frames, 2)
+	for _, frame := range exception.Stacktrace.Frames {
+		require.True(t, strings.HasSuffix(frame.Filename, "sentry_test.go"), frame.Filename)
+	}
+}
+
+func TestSentryReportNil(t *testing.T) {
+	var reporter *sentryReporter
+	reporter.report(context.Background(), errors.New("foo"), nil)
+}
@@ -0,0 +1,196 @@
+// Package servicestest runs a service inside the tests of the application, with
+// the same interceptors, options and Sentry wiring of production.
+package servicestest
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/altipla-consulting/services/v2"
+	raven "github.com/getsentry/raven-go"
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+var (
+	errNotRunning = errors.New("service stopped before being ready")
+	errTimeout    = errors.New("timeout waiting for the service to be ready")
+)
+
+// Service is a service configured with ephemeral ports for a test. It embeds the
+// real service to configure it like in production.
+type Service struct {
+	*services.Service
+
+	httpListener  net.Listener
+	debugListener net.Listener
+	grpcListener  *bufconn.Listener
+	sentry        *sentryTransport
+}
+
+// Init creates a service like services.Init, listening in ephemeral ports and
+// capturing the Sentry reports instead of sending them. ConfigureSentry works
+// like in production when this service is used. Start it once it is configured.
+func Init(t testing.TB, name string, opts ...services.Option) *Service {
+	t.Helper()
+
+	// Each resource is released when the test finishes even if the next step
+	// fails. The cleanups run in the reverse order, after the service stops.
+	httpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen for the routing server: %v", err)
+	}
+	t.Cleanup(func() { httpListener.Close() })
+	debugListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen for the debug server: %v", err)
+	}
+	t.Cleanup(func() { debugListener.Close() })
+	grpcListener := bufconn.Listen(1024 * 1024)
+	t.Cleanup(func() { grpcListener.Close() })
+
+	sentry := new(sentryTransport)
+	opts = append([]services.Option{
+		services.WithHTTPListener(httpListener),
+		services.WithDebugListener(debugListener),
+		services.WithGRPCListener(grpcListener),
+		services.WithSentryTransport(sentry),
+	}, opts...)
+
+	return &Service{
+		Service:       services.Init(name, opts...),
+		httpListener:  httpListener,
+		debugListener: debugListener,
+		grpcListener:  grpcListener,
+		sentry:        sentry,
+	}
+}
+
+// Server is a service running in the background of a test.
+type Server struct {
+	// URL of the routing server, for example http://127.0.0.1:54321.
+	URL string
+
+	// DebugURL of the debug server with the health checks and pprof.
+	DebugURL string
+
+	// Client to send HTTP requests to the servers.
+	Client *http.Client
+
+	// Conn is a connection to the GRPC server. It is an in-memory connection,
+	// no real port is opened for it.
+	Conn *grpc.ClientConn
+
+	sentry *sentryTransport
+}
+
+// Start runs the service until the test finishes. It waits for the start hooks
+// and fails the test if the service cannot start.
+func Start(t testing.TB, service *Service) *Server {
+	t.Helper()
+
+	dialer := func(string, time.Duration) (net.Conn, error) {
+		return service.grpcListener.Dial()
+	}
+	conn, err := services.Dial(services.Endpoint("passthrough:///bufconn"), grpc.WithInsecure(), grpc.WithDialer(dialer))
+	if err != nil {
+		t.Fatalf("cannot dial the grpc server: %v", err)
+	}
+	// The connection is closed after the service stops, so the calls and streams
+	// still open see the shutdown of the server.
+	t.Cleanup(func() { conn.Close() })
+
+	server := &Server{
+		URL:      "http://" + service.httpListener.Addr().String(),
+		DebugURL: "http://" + service.debugListener.Addr().String(),
+		Client:   new(http.Client),
+		Conn:     conn,
+		sentry:   service.sentry,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- service.RunContext(ctx)
+	}()
+
+	if err := server.waitReady(done); err != nil {
+		cancel()
+		t.Fatalf("cannot start the service: %v", err)
+	}
+
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("service failed: %v", err)
+		}
+	})
+
+	return server
+}
+
+// SentryReports returns the messages of the errors reported to Sentry until now.
+// They are sent in the background, so they may appear after the call fails.
+func (server *Server) SentryReports() []string {
+	return server.sentry.messages()
+}
+
+// waitReady polls the liveness endpoint of the debug server until it answers,
+// that only happens after the start hooks have finished.
+func (server *Server) waitReady(done chan error) error {
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case err := <-done:
+			if err == nil {
+				return errNotRunning
+			}
+			return err
+
+		case <-timeout:
+			return errTimeout
+
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		resp, err := server.Client.Get(server.DebugURL + "/healthz")
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusOK {
+			return nil
+		}
+	}
+}
+
+// sentryTransport captures the Sentry reports of the service instead of sending
+// them.
+type sentryTransport struct {
+	mu      sync.Mutex
+	reports []string
+}
+
+func (transport *sentryTransport) Send(url, authHeader string, packet *raven.Packet) error {
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+
+	log.WithField("message", packet.Message).Info("Sentry report captured in the test")
+	transport.reports = append(transport.reports, packet.Message)
+
+	return nil
+}
+
+func (transport *sentryTransport) messages() []string {
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+
+	return append([]string(nil), transport.reports...)
+}
@@ -0,0 +1,163 @@
+package servicestest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/altipla-consulting/services/v2"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestStartDebugServer(t *testing.T) {
+	service := Init(t, "test")
+	service.AddHealthCheck("test", func(ctx context.Context) error { return nil })
+
+	server := Start(t, service)
+
+	resp, err := server.Client.Get(server.DebugURL + "/readyz")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, resp.StatusCode, http.StatusOK)
+}
+
+func TestStartDebugServerVersion(t *testing.T) {
+	service := Init(t, "test")
+
+	server := Start(t, service)
+
+	resp, err := server.Client.Get(server.DebugURL + "/version")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, resp.StatusCode, http.StatusOK)
+
+	var info services.BuildInfo
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&info))
+	require.Equal(t, info.Name, "test")
+	require.NotEmpty(t, info.GoVersion)
+}
+
+func TestStartGRPCServer(t *testing.T) {
+	service := Init(t, "test")
+	service.ConfigureGRPC()
+	service.GRPCServer()
+
+	server := Start(t, service)
+
+	client := healthpb.NewHealthClient(server.Conn)
+	reply, err := client.Check(context.Background(), new(healthpb.HealthCheckRequest))
+	require.NoError(t, err)
+
+	require.Equal(t, reply.Status, healthpb.HealthCheckResponse_SERVING)
+}
+
+func TestStartGRPCServerInterceptors(t *testing.T) {
+	var methods []string
+	interceptor := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		methods = append(methods, info.FullMethod)
+		return handler(ctx, req)
+	}
+
+	service := Init(t, "test")
+	service.ConfigureGRPC(
+		services.WithUnaryInterceptors(interceptor),
+		services.WithServerOptions(grpc.MaxRecvMsgSize(1024)),
+	)
+	service.GRPCServer()
+
+	server := Start(t, service)
+
+	client := healthpb.NewHealthClient(server.Conn)
+	_, err := client.Check(context.Background(), new(healthpb.HealthCheckRequest))
+	require.NoError(t, err)
+
+	require.Equal(t, methods, []string{"/grpc.health.v1.Health/Check"})
+}
+
+func TestStartSentryReports(t *testing.T) {
+	interceptor := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return nil, status.Error(codes.Internal, "foo")
+	}
+
+	service := Init(t, "test")
+	service.ConfigureSentry("https://key@sentry.example.com/1")
+	service.ConfigureGRPC(services.WithUnaryInterceptors(interceptor))
+	service.GRPCServer()
+
+	server := Start(t, service)
+
+	_, err := healthpb.NewHealthClient(server.Conn).Check(context.Background(), new(healthpb.HealthCheckRequest))
+	require.Error(t, err)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(server.SentryReports()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Equal(t, server.SentryReports(), []string{"rpc error: code = Internal desc = foo"})
+}
+
+func TestShutdownStopsOpenStreams(t *testing.T) {
+	service := Init(t, "test", services.WithGRPCShutdownTimeout(200*time.Millisecond))
+	service.ConfigureGRPC()
+	service.GRPCServer()
+
+	var stream healthpb.Health_WatchClient
+	var stopping time.Time
+	t.Run("run", func(t *testing.T) {
+		server := Start(t, service)
+
+		var err error
+		stream, err = healthpb.NewHealthClient(server.Conn).Watch(context.Background(), new(healthpb.HealthCheckRequest))
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		require.NoError(t, err)
+
+		stopping = time.Now()
+	})
+
+	// The open stream blocks the graceful stop until the deadline closes it.
+	require.True(t, time.Since(stopping) >= 200*time.Millisecond)
+	for {
+		if _, err := stream.Recv(); err != nil {
+			break
+		}
+	}
+}
+
+func TestShutdownDrainDelay(t *testing.T) {
+	service := Init(t, "test", services.WithDrainDelay(300*time.Millisecond))
+
+	statuses := make(chan int, 1000)
+	t.Run("run", func(t *testing.T) {
+		server := Start(t, service)
+
+		// Poll the readiness until the debug server stops.
+		go func() {
+			defer close(statuses)
+			for {
+				resp, err := server.Client.Get(server.DebugURL + "/readyz")
+				if err != nil {
+					return
+				}
+				resp.Body.Close()
+				statuses <- resp.StatusCode
+				time.Sleep(10 * time.Millisecond)
+			}
+		}()
+		time.Sleep(50 * time.Millisecond)
+	})
+
+	var seen []int
+	for status := range statuses {
+		seen = append(seen, status)
+	}
+	require.Equal(t, seen[0], http.StatusOK)
+	require.Equal(t, seen[len(seen)-1], http.StatusServiceUnavailable)
+}
@@ -1,35 +1,167 @@
 package services
 
 import (
+	"container/list"
 	"math/rand"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"go.opencensus.io/trace"
 )
 
+// SamplerConfig tunes the sampler of the traces. Every root span name has its own
+// counter of the traces in the last window of time. If it goes over the threshold
+// the name is rate limited and only one trace is sampled every interval until the
+// cooldown has passed without new rate limits.
+//
+// Zero values use the default of each field.
+type SamplerConfig struct {
+	// WindowSlots is the number of slots of the window. By default 40.
+	WindowSlots int
+
+	// SlotDuration is the time each slot of the window counts. By default 15 seconds.
+	SlotDuration time.Duration
+
+	// Threshold is the maximum number of traces inside the window before rate
+	// limiting the name. By default 20.
+	Threshold int64
+
+	// Cooldown is the time without reaching the threshold before tracing all the
+	// requests again. By default 48 hours.
+	Cooldown time.Duration
+
+	// MinInterval and MaxInterval are the limits of the random interval between
+	// samples when the name is rate limited. By default 5 and 15 minutes.
+	MinInterval time.Duration
+	MaxInterval time.Duration
+
+	// MaxNames is the maximum number of names tracked independently. Once it is
+	// reached new names share a single overflow counter until some of the old
+	// ones are evicted. By default 1000.
+	MaxNames int
+
+	// IdleTimeout is the time without traces after which the counter of a name is
+	// evicted. By default 1 hour.
+	IdleTimeout time.Duration
+
+	// ExcludePrefixes is a list of name prefixes that will never be traced. The
+	// background requests to the Stackdriver Profiler are always excluded.
+	ExcludePrefixes []string
+
+	// Overrides changes the configuration of some specific names. Zero values of
+	// the override use the global configuration.
+	Overrides map[string]SamplerOverride
+}
+
+// SamplerOverride changes the configuration of the sampler for a specific name.
+type SamplerOverride struct {
+	Threshold   int64
+	Cooldown    time.Duration
+	MinInterval time.Duration
+	MaxInterval time.Duration
+}
+
+func (config SamplerConfig) withDefaults() SamplerConfig {
+	if config.WindowSlots == 0 {
+		config.WindowSlots = 40
+	}
+	if config.SlotDuration == 0 {
+		config.SlotDuration = 15 * time.Second
+	}
+	if config.Threshold == 0 {
+		config.Threshold = 20
+	}
+	if config.Cooldown == 0 {
+		config.Cooldown = 48 * time.Hour
+	}
+	if config.MinInterval == 0 {
+		config.MinInterval = 5 * time.Minute
+	}
+	if config.MaxInterval == 0 {
+		config.MaxInterval = 15 * time.Minute
+	}
+	if config.MaxInterval < config.MinInterval {
+		config.MaxInterval = config.MinInterval
+	}
+	if config.MaxNames == 0 {
+		config.MaxNames = 1000
+	}
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = time.Hour
+	}
+	config.ExcludePrefixes = append([]string{"google.devtools.cloudprofiler."}, config.ExcludePrefixes...)
+
+	return config
+}
+
+// forName returns the configuration of a specific name applying the overrides.
+func (config SamplerConfig) forName(name string) SamplerOverride {
+	result := SamplerOverride{
+		Threshold:   config.Threshold,
+		Cooldown:    config.Cooldown,
+		MinInterval: config.MinInterval,
+		MaxInterval: config.MaxInterval,
+	}
+
+	override, ok := config.Overrides[name]
+	if !ok {
+		return result
+	}
+	if override.Threshold != 0 {
+		result.Threshold = override.Threshold
+	}
+	if override.Cooldown != 0 {
+		result.Cooldown = override.Cooldown
+	}
+	if override.MinInterval != 0 {
+		result.MinInterval = override.MinInterval
+	}
+	if override.MaxInterval != 0 {
+		result.MaxInterval = override.MaxInterval
+	}
+	if result.MaxInterval < result.MinInterval {
+		result.MaxInterval = result.MinInterval
+	}
+
+	return result
+}
+
 type windowCounter struct {
-	slots        []int64
+	slots []int64
+
+	// sum is the running total of the slots to avoid walking them in every
+	// decision.
+	sum int64
+
+	slotDuration time.Duration
 	pos          int
 	lastMove     time.Time
 	timeProvider func() time.Time
 
 	lastQuota       time.Time
 	nextMeasurement time.Time
+
+	sampled, dropped int64
+
+	// Position in the list of recently used counters of the sampler.
+	lastUsed time.Time
+	elem     *list.Element
 }
 
-func newWindowCounter() *windowCounter {
+func newWindowCounter(slots int, slotDuration time.Duration) *windowCounter {
 	return &windowCounter{
-		slots:        make([]int64, 40),
+		slots:        make([]int64, slots),
+		slotDuration: slotDuration,
 		lastMove:     time.Now(),
 		timeProvider: time.Now,
 	}
 }
 
 func (counter *windowCounter) incr(value int64) {
-	move := int(counter.timeProvider().Sub(counter.lastMove)) / int(15*time.Second)
+	move := int(counter.timeProvider().Sub(counter.lastMove) / counter.slotDuration)
 	size := len(counter.slots)
 
 	if move > size {
@@ -37,6 +169,7 @@ func (counter *windowCounter) incr(value int64) {
 		for i := 0; i < size; i++ {
 			counter.slots[i] = 0
 		}
+		counter.sum = 0
 		counter.lastMove = counter.timeProvider()
 	}
 
@@ -46,41 +179,89 @@ func (counter *windowCounter) incr(value int64) {
 			if counter.pos >= size {
 				counter.pos = 0
 			}
+			counter.sum -= counter.slots[counter.pos]
 			counter.slots[counter.pos] = 0
 		}
 		counter.lastMove = counter.timeProvider()
 	}
 
 	counter.slots[counter.pos] += value
+	counter.sum += value
 }
 
-func (counter *windowCounter) total() (result int64) {
+func (counter *windowCounter) total() int64 {
 	// Force cleaning of old values
 	counter.incr(0)
 
-	for i := 0; i < len(counter.slots); i++ {
-		result += counter.slots[i]
-	}
-	return
+	return counter.sum
 }
 
+// samplerOverflowName is the name of the counter shared by all the names that
+// do not fit in the sampler.
+const samplerOverflowName = "(overflow)"
+
+// samplerShards is the number of independent locks of the sampler. Root spans
+// with different names rarely contend with each other.
+const samplerShards = 64
+
 type customSampler struct {
-	mu       *sync.Mutex
+	// names is the number of counters tracked in all the shards. It is the first
+	// field to keep it aligned for the atomic operations.
+	names int64
+
+	// lastSweep is the time in nanoseconds of the last eviction of idle counters
+	// in all the shards.
+	lastSweep int64
+
+	config SamplerConfig
+	shards []*samplerShard
+
+	// timeProvider and random are the clock and the source of the random
+	// intervals. They can be replaced in tests; random should be safe to use
+	// from multiple goroutines.
+	timeProvider func() time.Time
+	random       func(n int64) int64
+
+	overflowMu sync.Mutex
+	overflow   *windowCounter
+
+	// tail buffers the traces dropped by the sampler if tail sampling is enabled.
+	tail *tailSampler
+}
+
+type samplerShard struct {
+	mu       sync.Mutex
 	counters map[string]*windowCounter
+
+	// recent has the names of the counters from the most recently used to the
+	// least one.
+	recent *list.List
 }
 
-func newCustomSampler() *customSampler {
-	return &customSampler{
-		mu:       new(sync.Mutex),
-		counters: make(map[string]*windowCounter),
+func newCustomSampler(config SamplerConfig) *customSampler {
+	sampler := &customSampler{
+		config:       config.withDefaults(),
+		shards:       make([]*samplerShard, samplerShards),
+		timeProvider: time.Now,
+		random:       rand.Int63n,
+	}
+	for i := range sampler.shards {
+		sampler.shards[i] = &samplerShard{
+			counters: make(map[string]*windowCounter),
+			recent:   list.New(),
+		}
 	}
+	return sampler
 }
 
 func (sampler *customSampler) Sampler() trace.Sampler {
 	return func(params trace.SamplingParameters) trace.SamplingDecision {
-		// Do not trace requests to the profiler that happen in the background.
-		if strings.HasPrefix(params.Name, "google.devtools.cloudprofiler.") {
-			return trace.SamplingDecision{}
+		// Do not trace excluded names, like requests to the profiler that happen
+		// in the background.
+		for _, prefix := range sampler.config.ExcludePrefixes {
+			if strings.HasPrefix(params.Name, prefix) {
+				return trace.SamplingDecision{}
+			}
 		}
 
 		// If a parent decides to log we send all the tracing through the services.
@@ -88,49 +269,192 @@ func (sampler *customSampler) Sampler() trace.Sampler {
 			return trace.SamplingDecision{Sample: true}
 		}
 
-		// We have a parent that decided not to log; we don't log either.
+		// We have a parent that decided not to log; we don't log either unless it
+		// is in another process and the tail sampler keeps the call if it fails.
 		empty := trace.SpanContext{}
 		if params.ParentContext != empty {
+			if params.HasRemoteParent && sampler.tail != nil && sampler.tail.track(params.TraceID, params.SpanID) {
+				return trace.SamplingDecision{Sample: true}
+			}
 			return trace.SamplingDecision{}
 		}
 
-		sampler.mu.Lock()
-		defer sampler.mu.Unlock()
+		if sampler.sampleRoot(params.Name) {
+			return trace.SamplingDecision{Sample: true}
+		}
 
-		counter, ok := sampler.counters[params.Name]
-		if !ok {
-			counter = newWindowCounter()
-			sampler.counters[params.Name] = counter
+		// Record the trace anyway to decide when it finishes if it should be kept.
+		if sampler.tail != nil && sampler.tail.track(params.TraceID, params.SpanID) {
+			return trace.SamplingDecision{Sample: true}
 		}
 
-		counter.incr(1)
+		return trace.SamplingDecision{}
+	}
+}
 
-		// We see an increase in the rate of traces, rate limit the endpoint.
-		total := counter.total()
-		if total > 20 {
-			if counter.lastQuota.IsZero() {
-				log.WithField("endpoint", params.Name).Info("Downgrade tracing to avoid sending too much data")
-			}
-			counter.lastQuota = time.Now()
+// sampleRoot decides if a root span should be sampled and records the decision.
+func (sampler *customSampler) sampleRoot(name string) bool {
+	now := sampler.timeProvider()
+
+	shard := sampler.shard(name)
+	shard.mu.Lock()
+	sampler.evictIdle(shard, now)
+
+	counter, ok := shard.counters[name]
+	if !ok && !sampler.reserveName() {
+		// Try to make space evicting the idle counters of the other shards before
+		// resorting to the overflow counter.
+		shard.mu.Unlock()
+		if !sampler.sweep(now) {
+			return sampler.sampleOverflow(now)
 		}
+		shard.mu.Lock()
 
-		// Standard case, no rate limiting measures are taken.
-		if counter.lastQuota.IsZero() {
-			return trace.SamplingDecision{Sample: true}
+		counter, ok = shard.counters[name]
+		if !ok && !sampler.reserveName() {
+			shard.mu.Unlock()
+			return sampler.sampleOverflow(now)
 		}
+	}
 
-		// If 48h make the endpoint quiet again we start tracing everything aggresively again.
-		if time.Now().Sub(counter.lastQuota) > 48*time.Hour {
-			log.WithField("endpoint", params.Name).Info("Upgrade tracing to always again")
-			counter.lastQuota = time.Time{}
-			return trace.SamplingDecision{Sample: true}
+	if ok {
+		counter.lastUsed = now
+		shard.recent.MoveToFront(counter.elem)
+	} else {
+		counter = sampler.newCounter(now)
+		counter.elem = shard.recent.PushFront(name)
+		shard.counters[name] = counter
+	}
+
+	sample := sampler.record(name, counter, now)
+	shard.mu.Unlock()
+
+	return sample
+}
+
+func (sampler *customSampler) sampleOverflow(now time.Time) bool {
+	sampler.overflowMu.Lock()
+	defer sampler.overflowMu.Unlock()
+
+	if sampler.overflow == nil {
+		log.WithField("max-names", sampler.config.MaxNames).Warning("Too many names in the trace sampler, sharing a single counter for the new ones")
+		sampler.overflow = sampler.newCounter(now)
+	}
+	return sampler.record(samplerOverflowName, sampler.overflow, now)
+}
+
+func (sampler *customSampler) record(name string, counter *windowCounter, now time.Time) bool {
+	sample := sampler.decide(name, counter, now)
+	if sample {
+		counter.sampled++
+	} else {
+		counter.dropped++
+	}
+	return sample
+}
+
+// shard returns the shard of the name using the FNV-1a hash.
+func (sampler *customSampler) shard(name string) *samplerShard {
+	hash := uint32(2166136261)
+	for i := 0; i < len(name); i++ {
+		hash ^= uint32(name[i])
+		hash *= 16777619
+	}
+	return sampler.shards[hash%uint32(len(sampler.shards))]
+}
+
+// reserveName takes one of the free places for a new counter if there is any.
+func (sampler *customSampler) reserveName() bool {
+	max := int64(sampler.config.MaxNames)
+	for {
+		names := atomic.LoadInt64(&sampler.names)
+		if names >= max {
+			return false
 		}
+		if atomic.CompareAndSwapInt64(&sampler.names, names, names+1) {
+			return true
+		}
+	}
+}
 
-		// We take 1 trace every 5-15 minutes randomly.
-		if time.Now().After(counter.nextMeasurement) {
-			counter.nextMeasurement = time.Now().Add(5*time.Minute + time.Duration(rand.Intn(10*60))*time.Second)
-			return trace.SamplingDecision{Sample: true}
+func (sampler *customSampler) newCounter(now time.Time) *windowCounter {
+	counter := newWindowCounter(sampler.config.WindowSlots, sampler.config.SlotDuration)
+	counter.timeProvider = sampler.timeProvider
+	counter.lastMove = now
+	counter.lastUsed = now
+	return counter
+}
+
+// evictIdle removes the counters of the shard that have not been used since the
+// idle timeout. The lock of the shard should be held by the caller.
+func (sampler *customSampler) evictIdle(shard *samplerShard, now time.Time) {
+	for elem := shard.recent.Back(); elem != nil; elem = shard.recent.Back() {
+		name := elem.Value.(string)
+		if now.Sub(shard.counters[name].lastUsed) <= sampler.config.IdleTimeout {
+			return
 		}
-		return trace.SamplingDecision{}
+
+		shard.recent.Remove(elem)
+		delete(shard.counters, name)
+		atomic.AddInt64(&sampler.names, -1)
+	}
+}
+
+// sweep evicts the idle counters of all the shards. It runs at most once every
+// tenth of the idle timeout to avoid walking all the shards when the sampler is
+// full of active names. It returns false if the sweep was skipped.
+func (sampler *customSampler) sweep(now time.Time) bool {
+	last := atomic.LoadInt64(&sampler.lastSweep)
+	if last != 0 && now.UnixNano()-last < int64(sampler.config.IdleTimeout/10) {
+		return false
+	}
+	if !atomic.CompareAndSwapInt64(&sampler.lastSweep, last, now.UnixNano()) {
+		return false
+	}
+
+	for _, shard := range sampler.shards {
+		shard.mu.Lock()
+		sampler.evictIdle(shard, now)
+		shard.mu.Unlock()
+	}
+	return true
+}
+
+func (sampler *customSampler) decide(name string, counter *windowCounter, now time.Time) bool {
+	config := sampler.config.forName(name)
+
+	counter.incr(1)
+
+	// We see an increase in the rate of traces, rate limit the endpoint.
+	total := counter.total()
+	if total > config.Threshold {
+		if counter.lastQuota.IsZero() {
+			log.WithField("endpoint", name).Info("Downgrade tracing to avoid sending too much data")
+		}
+		counter.lastQuota = now
+	}
+
+	// Standard case, no rate limiting measures are taken.
+	if counter.lastQuota.IsZero() {
+		return true
+	}
+
+	// If the cooldown make the endpoint quiet again we start tracing everything
+	// aggresively again.
+	if now.Sub(counter.lastQuota) > config.Cooldown {
+		log.WithField("endpoint", name).Info("Upgrade tracing to always again")
+		counter.lastQuota = time.Time{}
+		return true
+	}
+
+	// We take 1 trace every interval randomly.
+	if now.After(counter.nextMeasurement) {
+		interval := config.MinInterval
+		if config.MaxInterval > config.MinInterval {
+			interval += time.Duration(sampler.random(int64(config.MaxInterval - config.MinInterval)))
+		}
+		counter.nextMeasurement = now.Add(interval)
+		return true
 	}
+	return false
 }
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// samplerState is the current state of the counter of a root span name.
+type samplerState struct {
+	Name            string
+	Total           int64
+	RateLimited     bool
+	LastQuota       time.Time
+	NextMeasurement time.Time
+	Sampled         int64
+	Dropped         int64
+}
+
+// snapshot returns the state of all the tracked names sorted by name.
+func (sampler *customSampler) snapshot() []samplerState {
+	now := sampler.timeProvider()
+
+	var states []samplerState
+	for _, shard := range sampler.shards {
+		shard.mu.Lock()
+		sampler.evictIdle(shard, now)
+		for name, counter := range shard.counters {
+			states = append(states, counter.state(name))
+		}
+		shard.mu.Unlock()
+	}
+
+	sampler.overflowMu.Lock()
+	if sampler.overflow != nil {
+		states = append(states, sampler.overflow.state(samplerOverflowName))
+	}
+	sampler.overflowMu.Unlock()
+
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+
+	return states
+}
+
+func (counter *windowCounter) state(name string) samplerState {
+	return samplerState{
+		Name:            name,
+		Total:           counter.total(),
+		RateLimited:     !counter.lastQuota.IsZero(),
+		LastQuota:       counter.lastQuota,
+		NextMeasurement: counter.nextMeasurement,
+		Sampled:         counter.sampled,
+		Dropped:         counter.dropped,
+	}
+}
+
+// debugHandler prints a table with the state of every name tracked by the sampler.
+func (sampler *customSampler) debugHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tWINDOW\tRATE LIMITED\tLAST QUOTA\tNEXT MEASUREMENT\tSAMPLED\tDROPPED")
+	for _, state := range sampler.snapshot() {
+		fmt.Fprintf(tw, "%s\t%d\t%v\t%s\t%s\t%d\t%d\n",
+			state.Name,
+			state.Total,
+			state.RateLimited,
+			formatSamplerTime(state.LastQuota),
+			formatSamplerTime(state.NextMeasurement),
+			state.Sampled,
+			state.Dropped)
+	}
+	tw.Flush()
+}
+
+func formatSamplerTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+var (
+	samplerWindowDesc = prometheus.NewDesc(
+		"trace_sampler_window_traces",
+		"Number of root spans counted in the current window of the sampler.",
+		[]string{"name"}, nil)
+	samplerRateLimitedDesc = prometheus.NewDesc(
+		"trace_sampler_rate_limited",
+		"Whether the name is rate limited by the sampler (1) or not (0).",
+		[]string{"name"}, nil)
+	samplerSampledDesc = prometheus.NewDesc(
+		"trace_sampler_sampled_total",
+		"Total number of root spans sampled.",
+		[]string{"name"}, nil)
+	samplerDroppedDesc = prometheus.NewDesc(
+		"trace_sampler_dropped_total",
+		"Total number of root spans dropped by the rate limit of the sampler.",
+		[]string{"name"}, nil)
+)
+
+// Describe implements prometheus.Collector.
+func (sampler *customSampler) Describe(ch chan<- *prometheus.Desc) {
+	ch <- samplerWindowDesc
+	ch <- samplerRateLimitedDesc
+	ch <- samplerSampledDesc
+	ch <- samplerDroppedDesc
+}
+
+// Collect implements prometheus.Collector.
+func (sampler *customSampler) Collect(ch chan<- prometheus.Metric) {
+	for _, state := range sampler.snapshot() {
+		var rateLimited float64
+		if state.RateLimited {
+			rateLimited = 1
+		}
+		ch <- prometheus.MustNewConstMetric(samplerWindowDesc, prometheus.GaugeValue, float64(state.Total), state.Name)
+		ch <- prometheus.MustNewConstMetric(samplerRateLimitedDesc, prometheus.GaugeValue, rateLimited, state.Name)
+		ch <- prometheus.MustNewConstMetric(samplerSampledDesc, prometheus.CounterValue, float64(state.Sampled), state.Name)
+		ch <- prometheus.MustNewConstMetric(samplerDroppedDesc, prometheus.CounterValue, float64(state.Dropped), state.Name)
+	}
+}
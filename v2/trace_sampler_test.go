@@ -1,18 +1,21 @@
 package services
 
 import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
+	"go.opencensus.io/trace"
 )
 
 func TestIncrementInPlace(t *testing.T) {
-	counter := &windowCounter{
-		slots:        make([]int64, 40),
-		lastMove:     time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC),
-		timeProvider: func() time.Time { return time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC) },
-	}
+	counter := testCounter(time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC))
 
 	counter.incr(1)
 
@@ -21,11 +24,7 @@ func TestIncrementInPlace(t *testing.T) {
 }
 
 func TestMultipleIncrements(t *testing.T) {
-	counter := &windowCounter{
-		slots:        make([]int64, 40),
-		lastMove:     time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC),
-		timeProvider: func() time.Time { return time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC) },
-	}
+	counter := testCounter(time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC))
 
 	counter.incr(1)
 	counter.incr(3)
@@ -35,11 +34,8 @@ func TestMultipleIncrements(t *testing.T) {
 }
 
 func TestMovementInsideCell(t *testing.T) {
-	counter := &windowCounter{
-		slots:        extendSlice([]int64{1}),
-		lastMove:     time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC),
-		timeProvider: func() time.Time { return time.Date(2018, 2, 1, 15, 14, 27, 0, time.UTC) },
-	}
+	counter := testCounter(time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC), 1)
+	counter.timeProvider = func() time.Time { return time.Date(2018, 2, 1, 15, 14, 27, 0, time.UTC) }
 
 	counter.incr(1)
 
@@ -48,11 +44,8 @@ func TestMovementInsideCell(t *testing.T) {
 }
 
 func TestMovementNextCell(t *testing.T) {
-	counter := &windowCounter{
-		slots:        extendSlice([]int64{1}),
-		lastMove:     time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC),
-		timeProvider: func() time.Time { return time.Date(2018, 2, 1, 15, 14, 28, 0, time.UTC) },
-	}
+	counter := testCounter(time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC), 1)
+	counter.timeProvider = func() time.Time { return time.Date(2018, 2, 1, 15, 14, 28, 0, time.UTC) }
 
 	counter.incr(1)
 
@@ -61,11 +54,8 @@ func TestMovementNextCell(t *testing.T) {
 }
 
 func TestMovementThroughUsedCells(t *testing.T) {
-	counter := &windowCounter{
-		slots:        extendSlice([]int64{1, 5, 6, 7}),
-		lastMove:     time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC),
-		timeProvider: func() time.Time { return time.Date(2018, 2, 1, 15, 14, 58, 0, time.UTC) },
-	}
+	counter := testCounter(time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC), 1, 5, 6, 7)
+	counter.timeProvider = func() time.Time { return time.Date(2018, 2, 1, 15, 14, 58, 0, time.UTC) }
 
 	counter.incr(1)
 
@@ -74,12 +64,9 @@ func TestMovementThroughUsedCells(t *testing.T) {
 }
 
 func TestMovementRing(t *testing.T) {
-	counter := &windowCounter{
-		slots:        extendSlice([]int64{1, 5, 6, 7}),
-		lastMove:     time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC),
-		timeProvider: func() time.Time { return time.Date(2018, 2, 1, 15, 14, 58, 0, time.UTC) },
-		pos:          39,
-	}
+	counter := testCounter(time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC), 1, 5, 6, 7)
+	counter.pos = 39
+	counter.timeProvider = func() time.Time { return time.Date(2018, 2, 1, 15, 14, 58, 0, time.UTC) }
 
 	counter.incr(1)
 
@@ -88,11 +75,8 @@ func TestMovementRing(t *testing.T) {
 }
 
 func TestMoreTimeThanSlots(t *testing.T) {
-	counter := &windowCounter{
-		slots:        extendSlice([]int64{1, 5, 6, 7}),
-		lastMove:     time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC),
-		timeProvider: func() time.Time { return time.Date(2018, 2, 1, 15, 34, 28, 0, time.UTC) },
-	}
+	counter := testCounter(time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC), 1, 5, 6, 7)
+	counter.timeProvider = func() time.Time { return time.Date(2018, 2, 1, 15, 34, 28, 0, time.UTC) }
 
 	counter.incr(1)
 
@@ -101,12 +85,9 @@ func TestMoreTimeThanSlots(t *testing.T) {
 }
 
 func TestExactTimeAsSlots(t *testing.T) {
-	counter := &windowCounter{
-		slots:        extendSlice([]int64{1, 5, 6, 7}),
-		lastMove:     time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC),
-		timeProvider: func() time.Time { return time.Date(2018, 2, 1, 15, 34, 13, 0, time.UTC) },
-		pos:          3,
-	}
+	counter := testCounter(time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC), 1, 5, 6, 7)
+	counter.pos = 3
+	counter.timeProvider = func() time.Time { return time.Date(2018, 2, 1, 15, 34, 13, 0, time.UTC) }
 
 	counter.incr(1)
 
@@ -115,16 +96,330 @@ func TestExactTimeAsSlots(t *testing.T) {
 }
 
 func TestTotalRemovingOld(t *testing.T) {
-	counter := &windowCounter{
-		slots:        extendSlice([]int64{1, 5, 6, 7, 8}),
-		lastMove:     time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC),
-		timeProvider: func() time.Time { return time.Date(2018, 2, 1, 15, 14, 58, 0, time.UTC) },
-	}
+	counter := testCounter(time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC), 1, 5, 6, 7, 8)
+	counter.timeProvider = func() time.Time { return time.Date(2018, 2, 1, 15, 14, 58, 0, time.UTC) }
 
 	require.EqualValues(t, counter.total(), 9)
 	require.Equal(t, counter.slots, extendSlice([]int64{1, 0, 0, 0, 8}))
 }
 
+func TestTotalRunningSum(t *testing.T) {
+	now := time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC)
+	counter := testCounter(now, 1, 5)
+	counter.timeProvider = func() time.Time { return now }
+
+	require.EqualValues(t, counter.total(), 6)
+	for i := 0; i < 200; i++ {
+		now = now.Add(7 * time.Second)
+		counter.incr(int64(i % 3))
+
+		var expected int64
+		for _, slot := range counter.slots {
+			expected += slot
+		}
+		require.EqualValues(t, counter.total(), expected)
+	}
+}
+
+func TestSamplerConfigDefaults(t *testing.T) {
+	config := SamplerConfig{ExcludePrefixes: []string{"foo."}}.withDefaults()
+
+	require.Equal(t, config.WindowSlots, 40)
+	require.Equal(t, config.SlotDuration, 15*time.Second)
+	require.EqualValues(t, config.Threshold, 20)
+	require.Equal(t, config.Cooldown, 48*time.Hour)
+	require.Equal(t, config.MaxNames, 1000)
+	require.Equal(t, config.IdleTimeout, time.Hour)
+	require.Equal(t, config.ExcludePrefixes, []string{"google.devtools.cloudprofiler.", "foo."})
+}
+
+func TestSamplerConfigOverrides(t *testing.T) {
+	config := SamplerConfig{
+		Threshold: 50,
+		Overrides: map[string]SamplerOverride{
+			"/foo.Bar/Baz": {Threshold: 5, MinInterval: time.Hour},
+		},
+	}.withDefaults()
+
+	require.Equal(t, config.forName("/foo.Bar/Qux"), SamplerOverride{
+		Threshold:   50,
+		Cooldown:    48 * time.Hour,
+		MinInterval: 5 * time.Minute,
+		MaxInterval: 15 * time.Minute,
+	})
+	require.Equal(t, config.forName("/foo.Bar/Baz"), SamplerOverride{
+		Threshold:   5,
+		Cooldown:    48 * time.Hour,
+		MinInterval: time.Hour,
+		MaxInterval: time.Hour,
+	})
+}
+
+func TestSamplerExcludePrefixes(t *testing.T) {
+	sampler := newCustomSampler(SamplerConfig{ExcludePrefixes: []string{"/grpc.health."}})
+
+	decision := sampler.Sampler()(trace.SamplingParameters{Name: "/grpc.health.v1.Health/Check"})
+	require.False(t, decision.Sample)
+
+	decision = sampler.Sampler()(trace.SamplingParameters{Name: "google.devtools.cloudprofiler.v2.ProfilerService/CreateProfile"})
+	require.False(t, decision.Sample)
+}
+
+func TestSamplerThreshold(t *testing.T) {
+	sampler := newCustomSampler(SamplerConfig{Threshold: 2})
+	fn := sampler.Sampler()
+	params := trace.SamplingParameters{Name: "/foo.Bar/Baz"}
+
+	require.True(t, fn(params).Sample)
+	require.True(t, fn(params).Sample)
+
+	// Rate limited, it takes the first measurement and then waits for the interval.
+	require.True(t, fn(params).Sample)
+	require.False(t, fn(params).Sample)
+}
+
+func TestSamplerSnapshot(t *testing.T) {
+	sampler := newCustomSampler(SamplerConfig{Threshold: 2})
+	fn := sampler.Sampler()
+	for i := 0; i < 4; i++ {
+		fn(trace.SamplingParameters{Name: "/foo.Bar/Baz"})
+	}
+	fn(trace.SamplingParameters{Name: "/foo.Bar/Qux"})
+
+	states := sampler.snapshot()
+	require.Len(t, states, 2)
+
+	require.Equal(t, states[0].Name, "/foo.Bar/Baz")
+	require.EqualValues(t, states[0].Total, 4)
+	require.True(t, states[0].RateLimited)
+	require.EqualValues(t, states[0].Sampled, 3)
+	require.EqualValues(t, states[0].Dropped, 1)
+
+	require.Equal(t, states[1].Name, "/foo.Bar/Qux")
+	require.False(t, states[1].RateLimited)
+	require.EqualValues(t, states[1].Sampled, 1)
+	require.EqualValues(t, states[1].Dropped, 0)
+}
+
+func TestSamplerDebugHandler(t *testing.T) {
+	sampler := newCustomSampler(SamplerConfig{Threshold: 2})
+	fn := sampler.Sampler()
+	for i := 0; i < 4; i++ {
+		fn(trace.SamplingParameters{Name: "/foo.Bar/Baz"})
+	}
+
+	w := httptest.NewRecorder()
+	sampler.debugHandler(w, httptest.NewRequest("GET", "/debug/sampler", nil))
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	require.Len(t, lines, 2)
+	require.Equal(t, strings.Fields(lines[0]), []string{"NAME", "WINDOW", "RATE", "LIMITED", "LAST", "QUOTA", "NEXT", "MEASUREMENT", "SAMPLED", "DROPPED"})
+	fields := strings.Fields(lines[1])
+	require.Equal(t, fields[:3], []string{"/foo.Bar/Baz", "4", "true"})
+	require.Equal(t, fields[5:], []string{"3", "1"})
+}
+
+func TestSamplerCollect(t *testing.T) {
+	sampler := newCustomSampler(SamplerConfig{Threshold: 2})
+	fn := sampler.Sampler()
+	for i := 0; i < 4; i++ {
+		fn(trace.SamplingParameters{Name: "/foo.Bar/Baz"})
+	}
+
+	expected := `
+		# HELP trace_sampler_dropped_total Total number of root spans dropped by the rate limit of the sampler.
+		# TYPE trace_sampler_dropped_total counter
+		trace_sampler_dropped_total{name="/foo.Bar/Baz"} 1
+		# HELP trace_sampler_rate_limited Whether the name is rate limited by the sampler (1) or not (0).
+		# TYPE trace_sampler_rate_limited gauge
+		trace_sampler_rate_limited{name="/foo.Bar/Baz"} 1
+		# HELP trace_sampler_sampled_total Total number of root spans sampled.
+		# TYPE trace_sampler_sampled_total counter
+		trace_sampler_sampled_total{name="/foo.Bar/Baz"} 3
+		# HELP trace_sampler_window_traces Number of root spans counted in the current window of the sampler.
+		# TYPE trace_sampler_window_traces gauge
+		trace_sampler_window_traces{name="/foo.Bar/Baz"} 4
+	`
+	require.NoError(t, testutil.CollectAndCompare(sampler, strings.NewReader(expected)))
+}
+
+func TestSamplerLifecycle(t *testing.T) {
+	now := time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC)
+	start := now
+	sampler := newCustomSampler(SamplerConfig{
+		Threshold:   2,
+		Cooldown:    time.Hour,
+		MinInterval: 5 * time.Minute,
+		MaxInterval: 15 * time.Minute,
+	})
+	sampler.timeProvider = func() time.Time { return now }
+	sampler.random = func(n int64) int64 {
+		require.EqualValues(t, n, 10*time.Minute)
+		return n / 2
+	}
+	fn := sampler.Sampler()
+	sample := func() bool {
+		return fn(trace.SamplingParameters{Name: "/foo.Bar/Baz"}).Sample
+	}
+
+	// Below the threshold everything is sampled.
+	require.True(t, sample())
+	require.True(t, sample())
+
+	// Over the threshold it takes the first measurement and then waits.
+	require.True(t, sample())
+	require.False(t, sample())
+	state := sampler.snapshot()[0]
+	require.True(t, state.RateLimited)
+	require.Equal(t, state.LastQuota, start)
+	require.Equal(t, state.NextMeasurement, start.Add(10*time.Minute))
+
+	// The next measurement arrives even if the window is quiet again.
+	now = start.Add(10*time.Minute + time.Second)
+	require.True(t, sample())
+	state = sampler.snapshot()[0]
+	require.True(t, state.RateLimited)
+	require.Equal(t, state.LastQuota, start)
+	require.Equal(t, state.NextMeasurement, now.Add(10*time.Minute))
+
+	now = now.Add(time.Minute)
+	require.False(t, sample())
+
+	// After the cooldown without reaching the threshold it samples everything.
+	now = start.Add(time.Hour + time.Minute)
+	require.True(t, sample())
+	require.True(t, sample())
+	state = sampler.snapshot()[0]
+	require.False(t, state.RateLimited)
+	require.EqualValues(t, state.Sampled, 6)
+	require.EqualValues(t, state.Dropped, 2)
+}
+
+func TestSamplerOverflow(t *testing.T) {
+	sampler := newCustomSampler(SamplerConfig{MaxNames: 2})
+	fn := sampler.Sampler()
+
+	fn(trace.SamplingParameters{Name: "/a"})
+	fn(trace.SamplingParameters{Name: "/b"})
+	fn(trace.SamplingParameters{Name: "/c"})
+	fn(trace.SamplingParameters{Name: "/d"})
+
+	require.Equal(t, samplerNames(sampler), []string{"(overflow)", "/a", "/b"})
+	require.EqualValues(t, sampler.overflow.total(), 2)
+}
+
+func TestSamplerEvictIdle(t *testing.T) {
+	now := time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC)
+	sampler := newCustomSampler(SamplerConfig{MaxNames: 2, IdleTimeout: time.Hour})
+	sampler.timeProvider = func() time.Time { return now }
+	fn := sampler.Sampler()
+
+	fn(trace.SamplingParameters{Name: "/a"})
+	now = now.Add(30 * time.Minute)
+	fn(trace.SamplingParameters{Name: "/b"})
+	now = now.Add(45 * time.Minute)
+
+	// The first name is idle and leaves space for the new one.
+	fn(trace.SamplingParameters{Name: "/c"})
+	require.Equal(t, samplerNames(sampler), []string{"/b", "/c"})
+	require.Nil(t, sampler.overflow)
+
+	// Using a name again keeps it alive.
+	fn(trace.SamplingParameters{Name: "/b"})
+	now = now.Add(50 * time.Minute)
+	fn(trace.SamplingParameters{Name: "/c"})
+	require.Equal(t, samplerNames(sampler), []string{"/b", "/c"})
+
+	now = now.Add(2 * time.Hour)
+	fn(trace.SamplingParameters{Name: "/d"})
+	require.Equal(t, samplerNames(sampler), []string{"/d"})
+}
+
+func TestSamplerConcurrent(t *testing.T) {
+	sampler := newCustomSampler(SamplerConfig{MaxNames: 10})
+	fn := sampler.Sampler()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				fn(trace.SamplingParameters{Name: fmt.Sprintf("/foo.Bar/Baz%d", (i*1000+j)%20)})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var total int64
+	for _, state := range sampler.snapshot() {
+		total += state.Sampled + state.Dropped
+	}
+	require.EqualValues(t, total, 8000)
+	require.Len(t, sampler.snapshot(), 11)
+}
+
+// BenchmarkSamplerSingleName shares the counter of a single name between all the
+// goroutines. The lock of the counter is held for a constant time because the
+// window keeps a running total.
+func BenchmarkSamplerSingleName(b *testing.B) {
+	log.SetLevel(log.WarnLevel)
+	defer log.SetLevel(log.InfoLevel)
+
+	fn := newCustomSampler(SamplerConfig{}).Sampler()
+	params := trace.SamplingParameters{Name: "/foo.Bar/Baz"}
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			fn(params)
+		}
+	})
+}
+
+// BenchmarkSamplerManyNames spreads the names between the shards of the sampler,
+// the throughput should grow with GOMAXPROCS (go test -bench Sampler -cpu 1,2,4,8).
+func BenchmarkSamplerManyNames(b *testing.B) {
+	log.SetLevel(log.WarnLevel)
+	defer log.SetLevel(log.InfoLevel)
+
+	fn := newCustomSampler(SamplerConfig{}).Sampler()
+	params := make([]trace.SamplingParameters, 256)
+	for i := range params {
+		params[i].Name = fmt.Sprintf("/foo.Bar/Baz%d", i)
+	}
+
+	b.RunParallel(func(pb *testing.PB) {
+		var i int
+		for pb.Next() {
+			fn(params[i%len(params)])
+			i++
+		}
+	})
+}
+
+func samplerNames(sampler *customSampler) []string {
+	var names []string
+	for _, state := range sampler.snapshot() {
+		names = append(names, state.Name)
+	}
+	return names
+}
+
+// testCounter builds a counter with the clock stopped at lastMove and the values
+// added to the first slots.
+func testCounter(lastMove time.Time, values ...int64) *windowCounter {
+	counter := newWindowCounter(40, 15*time.Second)
+	counter.lastMove = lastMove
+	counter.timeProvider = func() time.Time { return lastMove }
+	for i, value := range values {
+		counter.pos = i
+		counter.incr(value)
+	}
+	counter.pos = 0
+
+	return counter
+}
+
 func extendSlice(a []int64) []int64 {
 	r := make([]int64, 40)
 	copy(r, a)
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"go.opencensus.io/trace"
+)
+
+const (
+	// tailTraceTimeout is the time we keep the spans of a trace waiting for its
+	// root span to finish.
+	tailTraceTimeout = time.Minute
+
+	// tailMaxTraces limits the memory used by the buffered traces. New traces are
+	// dropped by the head sampler as usual when it is full.
+	tailMaxTraces = 10000
+
+	// tailDecisionTimeout is the time we remember the decision of a trace after
+	// its root span finishes, to apply it to the spans that finish later.
+	tailDecisionTimeout = 10 * time.Second
+)
+
+var (
+	activeTailMu sync.RWMutex
+	activeTail   *tailSampler
+)
+
+// setActiveTailSampler changes the tail sampler used by the propagation of the
+// traces. OpenCensus has a single global sampler, so there is only one active tail
+// sampler in the process.
+func setActiveTailSampler(sampler *tailSampler) {
+	activeTailMu.Lock()
+	defer activeTailMu.Unlock()
+
+	activeTail = sampler
+}
+
+// propagatedSpanContext clears the sampled flag of the traces recorded only by the
+// tail sampler before sending them to other services. The decision to keep them
+// is local; the other services sample the call with their own rules.
+func propagatedSpanContext(sc trace.SpanContext) trace.SpanContext {
+	activeTailMu.RLock()
+	sampler := activeTail
+	activeTailMu.RUnlock()
+
+	if sampler != nil && sc.IsSampled() && sampler.tracking(sc.TraceID) {
+		sc.TraceOptions = 0
+	}
+	return sc
+}
+
+// WithTailSampling keeps the traces that end with an error, or that take longer
+// than the latency threshold, even if the sampler decided to drop them when they
+// started. A latency of zero only keeps the failed ones.
+//
+// The root spans dropped by the sampler, and the calls received from other
+// services or load balancers that decided not to sample them, are recorded anyway
+// and buffered in memory until the local root finishes. The trace leaves the
+// process marked as not sampled, so the other services apply their own tail
+// sampling to the calls and only keep them if they fail or are slow there too.
+func WithTailSampling(latency time.Duration) TracerOption {
+	return func(service *Service) {
+		service.enableTailSampling = true
+		service.tailLatency = latency
+	}
+}
+
+// tailSampler buffers the spans of the traces the head sampler dropped and
+// exports them only if the root span failed or was too slow.
+type tailSampler struct {
+	next         TraceExporter
+	latency      time.Duration
+	timeProvider func() time.Time
+
+	mu        sync.Mutex
+	traces    map[trace.TraceID]*tailTrace
+	decisions map[trace.TraceID]tailDecision
+	lastSweep time.Time
+}
+
+type tailTrace struct {
+	created time.Time
+	root    trace.SpanID
+	spans   []*trace.SpanData
+}
+
+// tailDecision is remembered when the root span finishes. Spans that finish later
+// follow the same decision.
+type tailDecision struct {
+	decided time.Time
+	keep    bool
+}
+
+func newTailSampler(next TraceExporter, latency time.Duration) *tailSampler {
+	return &tailSampler{
+		next:         next,
+		latency:      latency,
+		timeProvider: time.Now,
+		traces:       make(map[trace.TraceID]*tailTrace),
+		decisions:    make(map[trace.TraceID]tailDecision),
+	}
+}
+
+// track starts buffering a trace dropped by the head sampler. The root is the
+// first span of the trace in this process; its parent may be in another service.
+// It returns false if there is no space left to buffer it.
+func (sampler *tailSampler) track(id trace.TraceID, root trace.SpanID) bool {
+	sampler.mu.Lock()
+	defer sampler.mu.Unlock()
+
+	now := sampler.timeProvider()
+	if now.Sub(sampler.lastSweep) > tailDecisionTimeout || len(sampler.traces) >= tailMaxTraces {
+		sampler.sweep(now)
+	}
+
+	// Another call of the same trace is already buffered; its root decides.
+	if _, ok := sampler.traces[id]; ok {
+		return true
+	}
+	if len(sampler.traces) >= tailMaxTraces {
+		return false
+	}
+	sampler.traces[id] = &tailTrace{created: now, root: root}
+
+	return true
+}
+
+// sweep removes the traces whose root span never finished and the old decisions.
+func (sampler *tailSampler) sweep(now time.Time) {
+	for id, t := range sampler.traces {
+		if now.Sub(t.created) > tailTraceTimeout {
+			delete(sampler.traces, id)
+		}
+	}
+	for id, decision := range sampler.decisions {
+		if now.Sub(decision.decided) > tailDecisionTimeout {
+			delete(sampler.decisions, id)
+		}
+	}
+	sampler.lastSweep = now
+}
+
+// tracking returns true if the trace was recorded only by the tail sampler.
+func (sampler *tailSampler) tracking(id trace.TraceID) bool {
+	sampler.mu.Lock()
+	defer sampler.mu.Unlock()
+
+	if _, ok := sampler.traces[id]; ok {
+		return true
+	}
+	_, ok := sampler.decisions[id]
+	return ok
+}
+
+func (sampler *tailSampler) ExportSpan(data *trace.SpanData) {
+	sampler.mu.Lock()
+	var export []*trace.SpanData
+	if decision, ok := sampler.decisions[data.TraceID]; ok {
+		if decision.keep {
+			export = []*trace.SpanData{data}
+		}
+	} else if t, ok := sampler.traces[data.TraceID]; ok {
+		if data.SpanID == t.root {
+			// Free the buffer as soon as the root finishes; only the decision is
+			// kept for the late spans.
+			keep := sampler.interesting(data)
+			if keep {
+				export = append(t.spans, data)
+			}
+			delete(sampler.traces, data.TraceID)
+			sampler.decisions[data.TraceID] = tailDecision{
+				decided: sampler.timeProvider(),
+				keep:    keep,
+			}
+		} else {
+			t.spans = append(t.spans, data)
+		}
+	} else {
+		export = []*trace.SpanData{data}
+	}
+	sampler.mu.Unlock()
+
+	for _, span := range export {
+		sampler.next.ExportSpan(span)
+	}
+}
+
+// interesting returns true if the root span failed or was too slow.
+func (sampler *tailSampler) interesting(data *trace.SpanData) bool {
+	if sampler.latency > 0 && data.EndTime.Sub(data.StartTime) >= sampler.latency {
+		return true
+	}
+
+	// Routing requests fail only with server errors; the clients receiving a 404
+	// are not interesting enough.
+	if code, ok := data.Attributes["http.status_code"].(int64); ok {
+		return code >= 500
+	}
+
+	// OpenCensus uses the GRPC codes for the status; anything different from OK
+	// is an error.
+	return data.Status.Code != 0
+}
+
+// Flush exports the traces whose root span has not finished yet if any of their
+// spans failed or was too slow, and then sends the pending spans of the next
+// exporter.
+func (sampler *tailSampler) Flush() {
+	sampler.mu.Lock()
+	var export []*trace.SpanData
+	for id, t := range sampler.traces {
+		for _, span := range t.spans {
+			if sampler.interesting(span) {
+				export = append(export, t.spans...)
+				break
+			}
+		}
+		delete(sampler.traces, id)
+	}
+	sampler.mu.Unlock()
+
+	for _, span := range export {
+		sampler.next.ExportSpan(span)
+	}
+	sampler.next.Flush()
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/trace"
+)
+
+type recordingExporter struct {
+	spans []*trace.SpanData
+}
+
+func (exporter *recordingExporter) ExportSpan(data *trace.SpanData) {
+	exporter.spans = append(exporter.spans, data)
+}
+
+func (exporter *recordingExporter) Flush() {}
+
+func tailSpan(traceID byte, spanID, parentID byte, duration time.Duration) *trace.SpanData {
+	start := time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC)
+	return &trace.SpanData{
+		SpanContext: trace.SpanContext{
+			TraceID: trace.TraceID{traceID},
+			SpanID:  trace.SpanID{spanID},
+		},
+		ParentSpanID: trace.SpanID{parentID},
+		StartTime:    start,
+		EndTime:      start.Add(duration),
+	}
+}
+
+func TestTailSamplerKeepsErrors(t *testing.T) {
+	exporter := new(recordingExporter)
+	sampler := newTailSampler(exporter, 0)
+	require.True(t, sampler.track(trace.TraceID{1}, trace.SpanID{1}))
+
+	sampler.ExportSpan(tailSpan(1, 2, 1, time.Millisecond))
+	require.Empty(t, exporter.spans)
+
+	root := tailSpan(1, 1, 0, time.Millisecond)
+	root.Status.Code = 13
+	sampler.ExportSpan(root)
+	require.Len(t, exporter.spans, 2)
+
+	// Late spans follow the decision of the root.
+	sampler.ExportSpan(tailSpan(1, 3, 1, time.Millisecond))
+	require.Len(t, exporter.spans, 3)
+}
+
+func TestTailSamplerDropsSuccess(t *testing.T) {
+	exporter := new(recordingExporter)
+	sampler := newTailSampler(exporter, time.Second)
+	require.True(t, sampler.track(trace.TraceID{1}, trace.SpanID{1}))
+
+	sampler.ExportSpan(tailSpan(1, 2, 1, time.Millisecond))
+	root := tailSpan(1, 1, 0, time.Millisecond)
+	root.Attributes = map[string]interface{}{"http.status_code": int64(404)}
+	root.Status.Code = 5
+	sampler.ExportSpan(root)
+	sampler.ExportSpan(tailSpan(1, 3, 1, time.Millisecond))
+
+	require.Empty(t, exporter.spans)
+}
+
+func TestTailSamplerKeepsSlow(t *testing.T) {
+	exporter := new(recordingExporter)
+	sampler := newTailSampler(exporter, time.Second)
+	require.True(t, sampler.track(trace.TraceID{1}, trace.SpanID{1}))
+
+	sampler.ExportSpan(tailSpan(1, 1, 0, 2*time.Second))
+
+	require.Len(t, exporter.spans, 1)
+}
+
+func TestTailSamplerUntracked(t *testing.T) {
+	exporter := new(recordingExporter)
+	sampler := newTailSampler(exporter, 0)
+
+	sampler.ExportSpan(tailSpan(1, 1, 0, time.Millisecond))
+
+	require.Len(t, exporter.spans, 1)
+}
+
+func TestSamplerRecordsDroppedForTail(t *testing.T) {
+	sampler := newCustomSampler(SamplerConfig{Threshold: 1})
+	sampler.tail = newTailSampler(new(recordingExporter), 0)
+	fn := sampler.Sampler()
+
+	for i := byte(0); i < 4; i++ {
+		require.True(t, fn(trace.SamplingParameters{Name: "/foo.Bar/Baz", TraceID: trace.TraceID{i}}).Sample)
+	}
+	require.Len(t, sampler.tail.traces, 2)
+	require.EqualValues(t, sampler.snapshot()[0].Dropped, 2)
+}
+
+func TestSamplerRecordsRemoteUnsampledForTail(t *testing.T) {
+	exporter := new(recordingExporter)
+	sampler := newCustomSampler(SamplerConfig{Threshold: 1})
+	sampler.tail = newTailSampler(exporter, 0)
+	fn := sampler.Sampler()
+
+	remote := trace.SpanContext{TraceID: trace.TraceID{1}, SpanID: trace.SpanID{9}}
+	require.True(t, fn(trace.SamplingParameters{
+		Name:            "/foo.Bar/Baz",
+		ParentContext:   remote,
+		TraceID:         trace.TraceID{1},
+		SpanID:          trace.SpanID{1},
+		HasRemoteParent: true,
+	}).Sample)
+
+	// Unsampled local parents are not recorded.
+	require.False(t, fn(trace.SamplingParameters{
+		Name:          "child",
+		ParentContext: trace.SpanContext{TraceID: trace.TraceID{2}, SpanID: trace.SpanID{1}},
+		TraceID:       trace.TraceID{2},
+		SpanID:        trace.SpanID{2},
+	}).Sample)
+
+	// The local root decides even if its parent is in another service.
+	sampler.tail.ExportSpan(tailSpan(1, 2, 1, time.Millisecond))
+	require.Empty(t, exporter.spans)
+	root := tailSpan(1, 1, 9, time.Millisecond)
+	root.Status.Code = 13
+	sampler.tail.ExportSpan(root)
+	require.Len(t, exporter.spans, 2)
+}
+
+func TestTailSamplerEvictsAfterRoot(t *testing.T) {
+	exporter := new(recordingExporter)
+	sampler := newTailSampler(exporter, time.Second)
+	now := time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC)
+	sampler.timeProvider = func() time.Time { return now }
+	require.True(t, sampler.track(trace.TraceID{1}, trace.SpanID{1}))
+
+	sampler.ExportSpan(tailSpan(1, 1, 0, time.Millisecond))
+	require.Empty(t, sampler.traces)
+	require.True(t, sampler.tracking(trace.TraceID{1}))
+
+	now = now.Add(tailDecisionTimeout + time.Second)
+	require.True(t, sampler.track(trace.TraceID{2}, trace.SpanID{1}))
+	require.False(t, sampler.tracking(trace.TraceID{1}))
+	require.Empty(t, exporter.spans)
+}
+
+func TestTailSamplerFlushKeepsErrors(t *testing.T) {
+	exporter := new(recordingExporter)
+	sampler := newTailSampler(exporter, time.Second)
+	require.True(t, sampler.track(trace.TraceID{1}, trace.SpanID{1}))
+	require.True(t, sampler.track(trace.TraceID{2}, trace.SpanID{1}))
+
+	failed := tailSpan(1, 2, 1, time.Millisecond)
+	failed.Status.Code = 13
+	sampler.ExportSpan(tailSpan(1, 3, 1, time.Millisecond))
+	sampler.ExportSpan(failed)
+	sampler.ExportSpan(tailSpan(2, 2, 1, time.Millisecond))
+	require.Empty(t, exporter.spans)
+
+	sampler.Flush()
+
+	require.Len(t, exporter.spans, 2)
+	require.Empty(t, sampler.traces)
+}
+
+func TestPropagatedSpanContext(t *testing.T) {
+	sampler := newTailSampler(new(recordingExporter), 0)
+	require.True(t, sampler.track(trace.TraceID{1}, trace.SpanID{1}))
+	setActiveTailSampler(sampler)
+	defer setActiveTailSampler(nil)
+
+	tracked := trace.SpanContext{TraceID: trace.TraceID{1}, SpanID: trace.SpanID{1}, TraceOptions: 1}
+	require.False(t, propagatedSpanContext(tracked).IsSampled())
+
+	sampled := trace.SpanContext{TraceID: trace.TraceID{2}, SpanID: trace.SpanID{1}, TraceOptions: 1}
+	require.True(t, propagatedSpanContext(sampled).IsSampled())
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	multiFormat{new(traceContextFormat), new(cloudTraceContextFormat)}.SpanContextToRequest(tracked, req)
+	require.Equal(t, req.Header.Get(traceparentHeader), "00-01000000000000000000000000000000-0100000000000000-00")
+	require.Equal(t, req.Header.Get(cloudTraceContextHeader), "01000000000000000000000000000000/72057594037927936;o=0")
+}
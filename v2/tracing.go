@@ -0,0 +1,121 @@
+package services
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"contrib.go.opencensus.io/exporter/stackdriver"
+	"github.com/juju/errors"
+	"go.opencensus.io/trace"
+)
+
+// TraceExporter receives the finished spans and sends them to a tracing backend.
+type TraceExporter interface {
+	trace.Exporter
+
+	// Flush sends all the pending spans before the application exits.
+	Flush()
+}
+
+// TracerOption configures the tracing of the service.
+type TracerOption func(service *Service)
+
+// WithSampler tunes the sampler of the traces. See SamplerConfig for the default
+// values of every field.
+func WithSampler(config SamplerConfig) TracerOption {
+	return func(service *Service) {
+		service.samplerConfig = config
+	}
+}
+
+// TracingBackend builds the exporter of the traces when the service starts.
+type TracingBackend struct {
+	name  string
+	build func(serviceName string) (TraceExporter, error)
+}
+
+// StackdriverTracing sends the traces to Stackdriver Trace in the Google project.
+func StackdriverTracing(googleProject string) TracingBackend {
+	return TracingBackend{
+		name: "stackdriver",
+		build: func(serviceName string) (TraceExporter, error) {
+			exporter, err := stackdriver.NewExporter(stackdriver.Options{ProjectID: googleProject})
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			return exporter, nil
+		},
+	}
+}
+
+// OTLPHTTPTracing sends the traces to an OpenTelemetry collector using the OTLP
+// protocol over HTTP with the JSON encoding. The URL should be the full address of
+// the traces endpoint, for example http://localhost:4318/v1/traces.
+//
+// The OTLP gRPC transport (port 4317) is not supported; its protobufs need a newer
+// GRPC version than the one of this package. Enable the HTTP receiver of the
+// collector instead.
+func OTLPHTTPTracing(url string) TracingBackend {
+	return TracingBackend{
+		name: "otlp-http",
+		build: func(serviceName string) (TraceExporter, error) {
+			return newOTLPHTTPExporter(serviceName, url), nil
+		},
+	}
+}
+
+// StdoutTracing writes every span as a JSON line to the writer. It is useful to
+// debug the traces locally.
+func StdoutTracing(w io.Writer) TracingBackend {
+	return TracingBackend{
+		name: "stdout",
+		build: func(serviceName string) (TraceExporter, error) {
+			return &jsonExporter{encoder: json.NewEncoder(w)}, nil
+		},
+	}
+}
+
+type jsonExporter struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+type jsonSpan struct {
+	TraceID      string                 `json:"traceId"`
+	SpanID       string                 `json:"spanId"`
+	ParentSpanID string                 `json:"parentSpanId,omitempty"`
+	Name         string                 `json:"name"`
+	Kind         int                    `json:"kind"`
+	Start        time.Time              `json:"start"`
+	End          time.Time              `json:"end"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	StatusCode   int32                  `json:"statusCode"`
+	Message      string                 `json:"message,omitempty"`
+}
+
+func (exporter *jsonExporter) ExportSpan(data *trace.SpanData) {
+	span := jsonSpan{
+		TraceID:    data.TraceID.String(),
+		SpanID:     data.SpanID.String(),
+		Name:       data.Name,
+		Kind:       data.SpanKind,
+		Start:      data.StartTime,
+		End:        data.EndTime,
+		Attributes: data.Attributes,
+		StatusCode: data.Status.Code,
+		Message:    data.Status.Message,
+	}
+	if data.ParentSpanID != (trace.SpanID{}) {
+		span.ParentSpanID = data.ParentSpanID.String()
+	}
+
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+
+	// Ignore write errors, there is nothing we can do if the output is closed.
+	_ = exporter.encoder.Encode(span)
+}
+
+func (exporter *jsonExporter) Flush() {}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/juju/errors"
+	"github.com/julienschmidt/httprouter"
+	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/trace"
+)
+
+// traceHTTP creates a span for every request of the router with the build
+// metadata. The incoming trace context of the load balancer or the caller is
+// honored, so the spans join its trace and follow its sampling decision.
+//
+// It should be called once all the routes are registered, the spans are named
+// with the patterns present in the router at that moment.
+func traceHTTP(router *httprouter.Router, build BuildInfo) http.Handler {
+	attrs := build.traceAttributes()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if span := trace.FromContext(r.Context()); span != nil {
+			span.AddAttributes(attrs...)
+		}
+		router.ServeHTTP(w, r)
+	})
+
+	routes, err := registeredRoutes(router)
+	if err != nil {
+		panic(fmt.Sprintf("cannot read the routes to name the spans: %s", err))
+	}
+	index := newRouteIndex(routes)
+	return &ochttp.Handler{
+		Handler: handler,
+		Propagation: multiFormat{
+			new(traceContextFormat),
+			new(cloudTraceContextFormat),
+		},
+		FormatSpanName: func(r *http.Request) string {
+			return routeSpanName(router, index, r)
+		},
+	}
+}
+
+// routeSpanName names the span with the pattern of the route instead of the raw
+// path to avoid creating a different span name for every ID of the URLs.
+func routeSpanName(router *httprouter.Router, index *routeIndex, r *http.Request) string {
+	if handle, _, _ := router.Lookup(r.Method, r.URL.Path); handle == nil {
+		return r.Method + " (not found)"
+	}
+	if pattern, ok := index.pattern(r); ok {
+		return r.Method + " " + pattern
+	}
+	return r.Method + " (unknown route)"
+}
+
+// routeIndex is a router with the same patterns of the application router. Its
+// handles only report the pattern that matched, so finding the route of a request
+// takes a single lookup with the same rules of the application router.
+type routeIndex struct {
+	router *httprouter.Router
+}
+
+func newRouteIndex(routes map[string][]string) *routeIndex {
+	index := &routeIndex{router: httprouter.New()}
+	for method, patterns := range routes {
+		for _, pattern := range patterns {
+			pattern := pattern
+			index.router.Handle(method, pattern, func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+				w.(*patternRecorder).pattern = pattern
+			})
+		}
+	}
+	return index
+}
+
+// pattern returns the pattern of the route that serves the request.
+func (index *routeIndex) pattern(r *http.Request) (string, bool) {
+	handle, _, _ := index.router.Lookup(r.Method, r.URL.Path)
+	if handle == nil {
+		return "", false
+	}
+
+	recorder := new(patternRecorder)
+	handle(recorder, r, nil)
+	return recorder.pattern, true
+}
+
+// patternRecorder receives the pattern from the handles of the route index. They
+// never write the response.
+type patternRecorder struct {
+	http.ResponseWriter
+	pattern string
+}
+
+// registeredRoutes returns the patterns registered in the router for every
+// method. The routes are registered by the routing package directly in the
+// router and httprouter does not export them, so we read its tree of nodes. It
+// returns an error if the fields are not the ones of the httprouter version
+// pinned in go.mod.
+func registeredRoutes(router *httprouter.Router) (map[string][]string, error) {
+	trees := reflect.ValueOf(router).Elem().FieldByName("trees")
+	if !trees.IsValid() || trees.Kind() != reflect.Map {
+		return nil, errors.NotSupportedf("httprouter without the trees of nodes")
+	}
+
+	routes := make(map[string][]string)
+	for _, method := range trees.MapKeys() {
+		err := walkRoutes(trees.MapIndex(method), "", func(pattern string) {
+			routes[method.String()] = append(routes[method.String()], pattern)
+		})
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return routes, nil
+}
+
+// walkRoutes calls fn with the full path of every node of the tree that has
+// a handler. The full path is the concatenation of the paths from the root.
+func walkRoutes(node reflect.Value, prefix string, fn func(pattern string)) error {
+	if node.Kind() != reflect.Ptr {
+		return errors.NotSupportedf("httprouter node of kind %s", node.Kind())
+	}
+	if node.IsNil() {
+		return nil
+	}
+	node = node.Elem()
+	if node.Kind() != reflect.Struct {
+		return errors.NotSupportedf("httprouter node of kind %s", node.Kind())
+	}
+
+	path, handle, children := node.FieldByName("path"), node.FieldByName("handle"), node.FieldByName("children")
+	if path.Kind() != reflect.String || handle.Kind() != reflect.Func || children.Kind() != reflect.Slice {
+		return errors.NotSupportedf("httprouter node without path, handle and children")
+	}
+
+	if !handle.IsNil() {
+		fn(prefix + path.String())
+	}
+	for i := 0; i < children.Len(); i++ {
+		if err := walkRoutes(children.Index(i), prefix+path.String(), fn); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,345 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	log "github.com/sirupsen/logrus"
+	"go.opencensus.io/trace"
+)
+
+const (
+	otlpBatchSize     = 512
+	otlpBatchInterval = 5 * time.Second
+	otlpSendTimeout   = 10 * time.Second
+
+	// otlpMaxPending limits the spans buffered while the collector is slow or
+	// down. New spans are dropped when it is reached.
+	otlpMaxPending = 8 * otlpBatchSize
+)
+
+// Values of the enums of the OTLP protocol. The JSON encoding sends them as
+// numbers.
+const (
+	otlpSpanKindInternal = 1
+	otlpSpanKindServer   = 2
+	otlpSpanKindClient   = 3
+
+	otlpStatusCodeError = 2
+)
+
+// otlpExporter converts the OpenCensus spans to the OTLP format and sends them
+// in batches to an OpenTelemetry collector. A single goroutine sends the batches
+// when they are full or when the interval passes, until the exporter is flushed
+// at shutdown.
+type otlpExporter struct {
+	resource otlpResource
+	send     func(ctx context.Context, req *otlpRequest) error
+
+	mu      sync.Mutex
+	pending []otlpSpan
+	dropped int
+
+	full     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newOTLPExporter(serviceName string, interval time.Duration, send func(ctx context.Context, req *otlpRequest) error) *otlpExporter {
+	exporter := &otlpExporter{
+		resource: otlpResource{
+			Attributes: []otlpKeyValue{
+				otlpStringAttribute("service.name", serviceName),
+				otlpStringAttribute("service.version", Version()),
+			},
+		},
+		send: send,
+		full: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go exporter.run(interval)
+
+	return exporter
+}
+
+func newOTLPHTTPExporter(serviceName, url string) *otlpExporter {
+	client := &http.Client{Timeout: otlpSendTimeout}
+
+	send := func(ctx context.Context, req *otlpRequest) error {
+		body, err := json.Marshal(req)
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		httpReq, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		httpReq = httpReq.WithContext(ctx)
+		httpReq.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(httpReq)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			msg, _ := ioutil.ReadAll(resp.Body)
+			return errors.Errorf("unexpected status %s from the collector: %s", resp.Status, msg)
+		}
+
+		return nil
+	}
+	return newOTLPExporter(serviceName, otlpBatchInterval, send)
+}
+
+func (exporter *otlpExporter) ExportSpan(data *trace.SpanData) {
+	exporter.mu.Lock()
+	if len(exporter.pending) >= otlpMaxPending {
+		exporter.dropped++
+		exporter.mu.Unlock()
+		return
+	}
+	exporter.pending = append(exporter.pending, newOTLPSpan(data))
+	full := len(exporter.pending) >= otlpBatchSize
+	exporter.mu.Unlock()
+
+	if full {
+		// Wake up the sender without blocking; if there is already a signal
+		// waiting the batch will be sent anyway.
+		select {
+		case exporter.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (exporter *otlpExporter) run(interval time.Duration) {
+	defer close(exporter.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			exporter.sendPending()
+		case <-exporter.full:
+			exporter.sendPending()
+		case <-exporter.stop:
+			return
+		}
+	}
+}
+
+// Flush stops the background sender and sends the remaining spans. It should be
+// called only once when the application exits.
+func (exporter *otlpExporter) Flush() {
+	exporter.stopOnce.Do(func() {
+		close(exporter.stop)
+	})
+	<-exporter.done
+
+	exporter.sendPending()
+}
+
+func (exporter *otlpExporter) sendPending() {
+	exporter.mu.Lock()
+	spans := exporter.pending
+	exporter.pending = nil
+	dropped := exporter.dropped
+	exporter.dropped = 0
+	exporter.mu.Unlock()
+
+	if dropped > 0 {
+		log.WithField("dropped", dropped).Warning("Traces dropped, the collector is not receiving them fast enough")
+	}
+
+	if len(spans) == 0 {
+		return
+	}
+
+	req := &otlpRequest{
+		ResourceSpans: []otlpResourceSpans{
+			{
+				Resource: exporter.resource,
+				ScopeSpans: []otlpScopeSpans{
+					{
+						Scope: otlpScope{Name: "github.com/altipla-consulting/services"},
+						Spans: spans,
+					},
+				},
+			},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), otlpSendTimeout)
+	defer cancel()
+
+	if err := exporter.send(ctx, req); err != nil {
+		log.WithFields(log.Fields{
+			"error": err.Error(),
+			"spans": len(spans),
+		}).Error("Cannot send traces to the collector")
+	}
+}
+
+// otlpRequest is the ExportTraceServiceRequest message of the OTLP protocol
+// with the JSON encoding: ids in hex, enums as numbers and 64 bits integers as
+// strings.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Links             []otlpLink     `json:"links,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpLink struct {
+	TraceID    string         `json:"traceId"`
+	SpanID     string         `json:"spanId"`
+	Attributes []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func newOTLPSpan(data *trace.SpanData) otlpSpan {
+	span := otlpSpan{
+		TraceID:           data.TraceID.String(),
+		SpanID:            data.SpanID.String(),
+		Name:              data.Name,
+		Kind:              otlpSpanKindInternal,
+		StartTimeUnixNano: otlpTime(data.StartTime),
+		EndTimeUnixNano:   otlpTime(data.EndTime),
+		Attributes:        otlpAttributes(data.Attributes),
+		Status:            otlpStatus{Message: data.Status.Message},
+	}
+	if data.ParentSpanID != (trace.SpanID{}) {
+		span.ParentSpanID = data.ParentSpanID.String()
+	}
+
+	switch data.SpanKind {
+	case trace.SpanKindServer:
+		span.Kind = otlpSpanKindServer
+	case trace.SpanKindClient:
+		span.Kind = otlpSpanKindClient
+	}
+
+	// OpenCensus uses the GRPC codes for the status; anything different from OK
+	// is an error.
+	if data.Status.Code != 0 {
+		span.Status.Code = otlpStatusCodeError
+	}
+
+	for _, annotation := range data.Annotations {
+		span.Events = append(span.Events, otlpEvent{
+			TimeUnixNano: otlpTime(annotation.Time),
+			Name:         annotation.Message,
+			Attributes:   otlpAttributes(annotation.Attributes),
+		})
+	}
+
+	for _, link := range data.Links {
+		span.Links = append(span.Links, otlpLink{
+			TraceID:    link.TraceID.String(),
+			SpanID:     link.SpanID.String(),
+			Attributes: otlpAttributes(link.Attributes),
+		})
+	}
+
+	return span
+}
+
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func otlpAttributes(attrs map[string]interface{}) []otlpKeyValue {
+	var result []otlpKeyValue
+	for key, value := range attrs {
+		kv := otlpKeyValue{Key: key}
+		switch v := value.(type) {
+		case string:
+			kv.Value.StringValue = &v
+		case bool:
+			kv.Value.BoolValue = &v
+		case int64:
+			s := strconv.FormatInt(v, 10)
+			kv.Value.IntValue = &s
+		case float64:
+			kv.Value.DoubleValue = &v
+		default:
+			s := fmt.Sprint(v)
+			kv.Value.StringValue = &s
+		}
+		result = append(result, kv)
+	}
+	return result
+}
+
+func otlpStringAttribute(key, value string) otlpKeyValue {
+	return otlpKeyValue{
+		Key:   key,
+		Value: otlpAnyValue{StringValue: &value},
+	}
+}
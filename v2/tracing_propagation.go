@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.opencensus.io/plugin/ocgrpc"
+	"go.opencensus.io/trace"
+	"go.opencensus.io/trace/propagation"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+)
+
+const (
+	traceparentHeader       = "traceparent"
+	cloudTraceContextHeader = "X-Cloud-Trace-Context"
+)
+
+// parseTraceparent reads a W3C Trace Context header with the format:
+// 00-<trace id>-<span id>-<flags>.
+func parseTraceparent(header string) (trace.SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return trace.SpanContext{}, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return trace.SpanContext{}, false
+	}
+
+	var sc trace.SpanContext
+	traceID, err := hex.DecodeString(parts[1])
+	if err != nil || len(traceID) != len(sc.TraceID) {
+		return trace.SpanContext{}, false
+	}
+	spanID, err := hex.DecodeString(parts[2])
+	if err != nil || len(spanID) != len(sc.SpanID) {
+		return trace.SpanContext{}, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return trace.SpanContext{}, false
+	}
+
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	if sc.TraceID == (trace.TraceID{}) || sc.SpanID == (trace.SpanID{}) {
+		return trace.SpanContext{}, false
+	}
+	sc.TraceOptions = trace.TraceOptions(flags[0] & 1)
+
+	return sc, true
+}
+
+func formatTraceparent(sc trace.SpanContext) string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, uint32(sc.TraceOptions)&1)
+}
+
+// traceContextFormat propagates the traces in HTTP requests using the W3C
+// Trace Context headers.
+type traceContextFormat struct{}
+
+func (format *traceContextFormat) SpanContextFromRequest(req *http.Request) (trace.SpanContext, bool) {
+	return parseTraceparent(req.Header.Get(traceparentHeader))
+}
+
+func (format *traceContextFormat) SpanContextToRequest(sc trace.SpanContext, req *http.Request) {
+	req.Header.Set(traceparentHeader, formatTraceparent(propagatedSpanContext(sc)))
+}
+
+// parseCloudTraceContext reads the header sent by the Google load balancers with
+// the format: <trace id>/<span id>;o=<options>. The span ID is a decimal number.
+func parseCloudTraceContext(header string) (trace.SpanContext, bool) {
+	header = strings.TrimSpace(header)
+	slash := strings.Index(header, "/")
+	if slash == -1 {
+		return trace.SpanContext{}, false
+	}
+
+	var sc trace.SpanContext
+	traceID, err := hex.DecodeString(header[:slash])
+	if err != nil || len(traceID) != len(sc.TraceID) {
+		return trace.SpanContext{}, false
+	}
+	copy(sc.TraceID[:], traceID)
+
+	spanPart := header[slash+1:]
+	var options string
+	if semicolon := strings.Index(spanPart, ";"); semicolon != -1 {
+		spanPart, options = spanPart[:semicolon], spanPart[semicolon+1:]
+	}
+	spanID, err := strconv.ParseUint(spanPart, 10, 64)
+	if err != nil {
+		return trace.SpanContext{}, false
+	}
+	binary.BigEndian.PutUint64(sc.SpanID[:], spanID)
+
+	if sc.TraceID == (trace.TraceID{}) || sc.SpanID == (trace.SpanID{}) {
+		return trace.SpanContext{}, false
+	}
+	if options == "o=1" {
+		sc.TraceOptions = 1
+	}
+
+	return sc, true
+}
+
+func formatCloudTraceContext(sc trace.SpanContext) string {
+	return fmt.Sprintf("%s/%d;o=%d", sc.TraceID, binary.BigEndian.Uint64(sc.SpanID[:]), uint32(sc.TraceOptions)&1)
+}
+
+// cloudTraceContextFormat propagates the traces in HTTP requests using the
+// header of the Google load balancers.
+type cloudTraceContextFormat struct{}
+
+func (format *cloudTraceContextFormat) SpanContextFromRequest(req *http.Request) (trace.SpanContext, bool) {
+	return parseCloudTraceContext(req.Header.Get(cloudTraceContextHeader))
+}
+
+func (format *cloudTraceContextFormat) SpanContextToRequest(sc trace.SpanContext, req *http.Request) {
+	req.Header.Set(cloudTraceContextHeader, formatCloudTraceContext(propagatedSpanContext(sc)))
+}
+
+// multiFormat reads the trace from the first format that is present in the
+// request and writes all of them in the outgoing ones.
+type multiFormat []propagation.HTTPFormat
+
+func (formats multiFormat) SpanContextFromRequest(req *http.Request) (trace.SpanContext, bool) {
+	for _, format := range formats {
+		if sc, ok := format.SpanContextFromRequest(req); ok {
+			return sc, true
+		}
+	}
+	return trace.SpanContext{}, false
+}
+
+func (formats multiFormat) SpanContextToRequest(sc trace.SpanContext, req *http.Request) {
+	for _, format := range formats {
+		format.SpanContextToRequest(sc, req)
+	}
+}
+
+// serverTraceHandler accepts the W3C Trace Context metadata in the incoming
+// calls besides the binary format of OpenCensus. It adds the build metadata to
+// the spans of the calls and streams.
+type serverTraceHandler struct {
+	*ocgrpc.ServerHandler
+	build BuildInfo
+}
+
+func (handler *serverTraceHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if ok && len(md.Get("grpc-trace-bin")) == 0 {
+		if values := md.Get(traceparentHeader); len(values) > 0 {
+			if sc, ok := parseTraceparent(values[0]); ok {
+				md = md.Copy()
+				md.Set("grpc-trace-bin", string(propagation.Binary(sc)))
+				ctx = metadata.NewIncomingContext(ctx, md)
+			}
+		}
+	}
+
+	ctx = handler.ServerHandler.TagRPC(ctx, info)
+	if span := trace.FromContext(ctx); span != nil {
+		span.AddAttributes(handler.build.traceAttributes()...)
+	}
+
+	return ctx
+}
+
+// clientTraceHandler sends the W3C Trace Context metadata in the outgoing
+// calls besides the binary format of OpenCensus. It adds the build metadata to
+// the spans of the calls, with the name of the app if we are serving a call.
+type clientTraceHandler struct {
+	*ocgrpc.ClientHandler
+	build BuildInfo
+}
+
+func (handler *clientTraceHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	ctx = handler.ClientHandler.TagRPC(ctx, info)
+	span := trace.FromContext(ctx)
+	if span == nil {
+		return ctx
+	}
+
+	build := handler.build
+	if caller, ok := ctx.Value(rpcCallerKey{}).(rpcCaller); ok {
+		build.Name = caller.app
+	}
+	span.AddAttributes(build.traceAttributes()...)
+
+	sc := span.SpanContext()
+	propagated := propagatedSpanContext(sc)
+	if propagated != sc {
+		// Replace the binary header written by OpenCensus, it has the sampled
+		// flag of the local span.
+		md, _ := metadata.FromOutgoingContext(ctx)
+		md.Set("grpc-trace-bin", string(propagation.Binary(propagated)))
+		ctx = metadata.NewOutgoingContext(ctx, md)
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, traceparentHeader, formatTraceparent(propagated))
+}
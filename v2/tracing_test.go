@@ -0,0 +1,267 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/trace"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	sc, ok := parseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	require.True(t, ok)
+
+	require.Equal(t, sc.TraceID.String(), "0af7651916cd43dd8448eb211c80319c")
+	require.Equal(t, sc.SpanID.String(), "b7ad6b7169203331")
+	require.True(t, sc.IsSampled())
+}
+
+func TestParseTraceparentInvalid(t *testing.T) {
+	headers := []string{
+		"",
+		"foo",
+		"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra",
+		"00-0af7651916cd43dd-b7ad6b7169203331-01",
+	}
+	for _, header := range headers {
+		_, ok := parseTraceparent(header)
+		require.False(t, ok, header)
+	}
+}
+
+func TestTraceContextFormatRoundTrip(t *testing.T) {
+	sc, ok := parseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00")
+	require.True(t, ok)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	format := new(traceContextFormat)
+	format.SpanContextToRequest(sc, req)
+
+	require.Equal(t, req.Header.Get("traceparent"), "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00")
+
+	parsed, ok := format.SpanContextFromRequest(req)
+	require.True(t, ok)
+	require.Equal(t, parsed, sc)
+}
+
+func TestParseCloudTraceContext(t *testing.T) {
+	sc, ok := parseCloudTraceContext("105445aa7843bc8bf206b12000100000/13211055540431159297;o=1")
+	require.True(t, ok)
+
+	require.Equal(t, sc.TraceID.String(), "105445aa7843bc8bf206b12000100000")
+	require.Equal(t, sc.SpanID.String(), "b7571904d35b0001")
+	require.True(t, sc.IsSampled())
+
+	require.Equal(t, formatCloudTraceContext(sc), "105445aa7843bc8bf206b12000100000/13211055540431159297;o=1")
+}
+
+func TestParseCloudTraceContextNotSampled(t *testing.T) {
+	sc, ok := parseCloudTraceContext("105445aa7843bc8bf206b12000100000/1")
+	require.True(t, ok)
+	require.False(t, sc.IsSampled())
+
+	_, ok = parseCloudTraceContext("105445aa7843bc8bf206b12000100000")
+	require.False(t, ok)
+	_, ok = parseCloudTraceContext("105445aa7843bc8bf206b12000100000/foo;o=1")
+	require.False(t, ok)
+}
+
+func TestMultiFormatPrefersTraceparent(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Cloud-Trace-Context", "105445aa7843bc8bf206b12000100000/1;o=1")
+	format := multiFormat{new(traceContextFormat), new(cloudTraceContextFormat)}
+
+	sc, ok := format.SpanContextFromRequest(req)
+	require.True(t, ok)
+	require.Equal(t, sc.TraceID.String(), "105445aa7843bc8bf206b12000100000")
+
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	sc, ok = format.SpanContextFromRequest(req)
+	require.True(t, ok)
+	require.Equal(t, sc.TraceID.String(), "0af7651916cd43dd8448eb211c80319c")
+}
+
+func TestRouteSpanName(t *testing.T) {
+	handle := func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {}
+	router := httprouter.New()
+	router.GET("/health", handle)
+	router.GET("/users/:id", handle)
+	router.GET("/users/:id/items/:item", handle)
+	router.GET("/users/:id/users", handle)
+	router.POST("/users/:id", handle)
+	router.GET("/static/:name/*filepath", handle)
+	routes, err := registeredRoutes(router)
+	require.NoError(t, err)
+	index := newRouteIndex(routes)
+	router.GET("/late", handle)
+
+	tests := []struct {
+		method, path, name string
+	}{
+		{http.MethodGet, "/health", "GET /health"},
+		{http.MethodGet, "/users/123", "GET /users/:id"},
+		{http.MethodPost, "/users/123", "POST /users/:id"},
+		{http.MethodGet, "/users/123/items/123", "GET /users/:id/items/:item"},
+		{http.MethodGet, "/users/users/users", "GET /users/:id/users"},
+		{http.MethodGet, "/static/foo/css/app.css", "GET /static/:name/*filepath"},
+		{http.MethodGet, "/missing", "GET (not found)"},
+		{http.MethodGet, "/late", "GET (unknown route)"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.path, nil)
+		require.Equal(t, routeSpanName(router, index, r), test.name, test.path)
+	}
+}
+
+// registeredRoutes reads the unexported fields of httprouter. Check that the
+// routes are still found before updating this version.
+func TestRegisteredRoutesVersion(t *testing.T) {
+	content, err := ioutil.ReadFile("go.mod")
+	require.NoError(t, err)
+	require.Contains(t, string(content), "github.com/julienschmidt/httprouter v0.0.0-20180715161854-348b672cd90d\n")
+
+	router := httprouter.New()
+	router.GET("/users/:id", func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {})
+	routes, err := registeredRoutes(router)
+	require.NoError(t, err)
+	require.Equal(t, routes, map[string][]string{http.MethodGet: {"/users/:id"}})
+}
+
+func TestTraceHTTPBuildAttributes(t *testing.T) {
+	exporter := new(recordingExporter)
+	trace.RegisterExporter(exporter)
+	defer trace.UnregisterExporter(exporter)
+
+	router := httprouter.New()
+	router.GET("/users/:id", func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {})
+	handler := traceHTTP(router, BuildInfo{Name: "test", Version: "v1.2.3"})
+
+	req := httptest.NewRequest(http.MethodGet, "/users/123", nil)
+	req.Header.Set(traceparentHeader, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	require.Len(t, exporter.spans, 1)
+	require.Equal(t, exporter.spans[0].Attributes["app"], "test")
+	require.Equal(t, exporter.spans[0].Attributes["version"], "v1.2.3")
+	require.NotContains(t, exporter.spans[0].Attributes, "commit")
+}
+
+func TestStdoutTracing(t *testing.T) {
+	var buf bytes.Buffer
+	exporter, err := StdoutTracing(&buf).build("test")
+	require.NoError(t, err)
+
+	sc, _ := parseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	exporter.ExportSpan(&trace.SpanData{
+		SpanContext: sc,
+		Name:        "/foo.Bar/Baz",
+		StartTime:   time.Date(2018, 2, 1, 15, 14, 13, 0, time.UTC),
+		EndTime:     time.Date(2018, 2, 1, 15, 14, 14, 0, time.UTC),
+	})
+
+	require.JSONEq(t, buf.String(), `{
+		"traceId": "0af7651916cd43dd8448eb211c80319c",
+		"spanId": "b7ad6b7169203331",
+		"name": "/foo.Bar/Baz",
+		"kind": 0,
+		"start": "2018-02-01T15:14:13Z",
+		"end": "2018-02-01T15:14:14Z",
+		"statusCode": 0
+	}`)
+}
+
+func TestOTLPExporterFlush(t *testing.T) {
+	var requests []*otlpRequest
+	exporter := newOTLPExporter("test", time.Hour, func(ctx context.Context, req *otlpRequest) error {
+		requests = append(requests, req)
+		return nil
+	})
+
+	sc, _ := parseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	exporter.ExportSpan(&trace.SpanData{
+		SpanContext: sc,
+		SpanKind:    trace.SpanKindServer,
+		Name:        "/foo.Bar/Baz",
+		Attributes:  map[string]interface{}{"app": "test"},
+		Status:      trace.Status{Code: 5, Message: "not found"},
+	})
+	exporter.Flush()
+
+	require.Len(t, requests, 1)
+	spans := requests[0].ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 1)
+	require.Equal(t, spans[0].Name, "/foo.Bar/Baz")
+	require.Equal(t, spans[0].Kind, otlpSpanKindServer)
+	require.Equal(t, spans[0].Status.Code, otlpStatusCodeError)
+	require.Equal(t, *spans[0].Attributes[0].Value.StringValue, "test")
+}
+
+func TestOTLPExporterFullBatch(t *testing.T) {
+	sent := make(chan int, 1)
+	exporter := newOTLPExporter("test", time.Hour, func(ctx context.Context, req *otlpRequest) error {
+		sent <- len(req.ResourceSpans[0].ScopeSpans[0].Spans)
+		return nil
+	})
+	defer exporter.Flush()
+
+	sc, _ := parseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	for i := 0; i < otlpBatchSize; i++ {
+		exporter.ExportSpan(&trace.SpanData{SpanContext: sc, Name: "foo"})
+	}
+
+	select {
+	case n := <-sent:
+		require.Equal(t, n, otlpBatchSize)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the full batch was not sent")
+	}
+}
+
+func TestOTLPExporterDropsWhenFull(t *testing.T) {
+	release := make(chan struct{})
+	exporter := newOTLPExporter("test", time.Hour, func(ctx context.Context, req *otlpRequest) error {
+		<-release
+		return nil
+	})
+
+	sc, _ := parseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	for i := 0; i < 3*otlpMaxPending; i++ {
+		exporter.ExportSpan(&trace.SpanData{SpanContext: sc, Name: "foo"})
+	}
+
+	// At most one batch is waiting for the collector and another one is queued.
+	exporter.mu.Lock()
+	require.True(t, len(exporter.pending) <= otlpMaxPending)
+	require.True(t, exporter.dropped >= otlpMaxPending)
+	exporter.mu.Unlock()
+
+	close(release)
+	exporter.Flush()
+}
+
+func TestOTLPJSONEncoding(t *testing.T) {
+	sc, _ := parseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	span := newOTLPSpan(&trace.SpanData{
+		SpanContext:  sc,
+		ParentSpanID: trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+		Name:         "foo",
+		StartTime:    time.Unix(1, 5),
+		EndTime:      time.Unix(2, 0),
+		Attributes:   map[string]interface{}{"size": int64(42)},
+	})
+
+	encoded, err := json.Marshal(span)
+	require.NoError(t, err)
+	require.Equal(t, string(encoded), `{"traceId":"0af7651916cd43dd8448eb211c80319c","spanId":"b7ad6b7169203331","parentSpanId":"0102030405060708","name":"foo","kind":1,"startTimeUnixNano":"1000000005","endTimeUnixNano":"2000000000","attributes":[{"key":"size","value":{"intValue":"42"}}],"status":{}}`)
+}